@@ -2,6 +2,7 @@ package api
 
 import (
 	"encoding/json"
+	"errors"
 	"fmt"
 	"io"
 	"log"
@@ -29,11 +30,15 @@ func SetupRoutes(db *gorm.DB) {
 	})
 
 	http.HandleFunc("/actionchains/", func(w http.ResponseWriter, r *http.Request) {
-		id := r.URL.Path[len("/actionchains/"):]
+		id, sub, _ := strings.Cut(r.URL.Path[len("/actionchains/"):], "/")
 		if id == "" {
 			http.Error(w, "ID is required", http.StatusBadRequest)
 			return
 		}
+		if sub != "" {
+			handleActionChainSubroute(db, w, r, id, sub)
+			return
+		}
 		switch r.Method {
 		case http.MethodGet:
 			handleGetActionChain(db, w, id)
@@ -80,6 +85,106 @@ func SetupRoutes(db *gorm.DB) {
 		}
 	})
 
+	// Run history routes
+	http.HandleFunc("/runs/", func(w http.ResponseWriter, r *http.Request) {
+		id := r.URL.Path[len("/runs/"):]
+		if id == "" {
+			http.Error(w, "ID is required", http.StatusBadRequest)
+			return
+		}
+		switch r.Method {
+		case http.MethodGet:
+			handleGetRun(db, w, id)
+		default:
+			http.Error(w, "Method not allowed", http.StatusMethodNotAllowed)
+		}
+	})
+
+	// Webhook triggers of active chains
+	http.HandleFunc("/hooks/", models.ServeHooks)
+
+	// Runtime state of the active chains
+	http.HandleFunc("/runtime/chains", func(w http.ResponseWriter, r *http.Request) {
+		if r.Method != http.MethodGet {
+			http.Error(w, "Method not allowed", http.StatusMethodNotAllowed)
+			return
+		}
+		json.NewEncoder(w).Encode(models.Runtime.List())
+	})
+
+	// Trigger types that action chains can use
+	http.HandleFunc("/runtime/triggers", func(w http.ResponseWriter, r *http.Request) {
+		if r.Method != http.MethodGet {
+			http.Error(w, "Method not allowed", http.StatusMethodNotAllowed)
+			return
+		}
+		json.NewEncoder(w).Encode(models.ListTriggerTypes())
+	})
+
+	// Message counts of the internal job queues
+	http.HandleFunc("/queues", func(w http.ResponseWriter, r *http.Request) {
+		if r.Method != http.MethodGet {
+			http.Error(w, "Method not allowed", http.StatusMethodNotAllowed)
+			return
+		}
+		handleListQueues(db, w)
+	})
+
+	// Pending waits of runs suspended by delay and approval actions
+	http.HandleFunc("/waits", func(w http.ResponseWriter, r *http.Request) {
+		if r.Method != http.MethodGet {
+			http.Error(w, "Method not allowed", http.StatusMethodNotAllowed)
+			return
+		}
+		handleListWaits(db, w)
+	})
+	http.HandleFunc("/waits/", func(w http.ResponseWriter, r *http.Request) {
+		id := r.URL.Path[len("/waits/"):]
+		if id == "" {
+			http.Error(w, "ID is required", http.StatusBadRequest)
+			return
+		}
+		switch r.Method {
+		case http.MethodDelete:
+			handleCancelWait(db, w, id)
+		default:
+			http.Error(w, "Method not allowed", http.StatusMethodNotAllowed)
+		}
+	})
+
+	// Approvals requested by approval actions
+	http.HandleFunc("/approvals", func(w http.ResponseWriter, r *http.Request) {
+		if r.Method != http.MethodGet {
+			http.Error(w, "Method not allowed", http.StatusMethodNotAllowed)
+			return
+		}
+		handleListApprovals(db, w)
+	})
+	http.HandleFunc("/approvals/", func(w http.ResponseWriter, r *http.Request) {
+		id := r.URL.Path[len("/approvals/"):]
+		if id == "" {
+			http.Error(w, "ID is required", http.StatusBadRequest)
+			return
+		}
+		switch r.Method {
+		case http.MethodGet:
+			handleGetApproval(db, w, id)
+		case http.MethodPost:
+			handleDecideApproval(db, w, r, id)
+		default:
+			http.Error(w, "Method not allowed", http.StatusMethodNotAllowed)
+		}
+	})
+
+	// Server status, including the chains restored at startup
+	http.HandleFunc("/status", func(w http.ResponseWriter, r *http.Request) {
+		if r.Method != http.MethodGet {
+			http.Error(w, "Method not allowed", http.StatusMethodNotAllowed)
+			return
+		}
+		handleGetStatus(w)
+	})
+
 	// New route for adding secrets to .env file
 	http.HandleFunc("/secrets", func(w http.ResponseWriter, r *http.Request) {
 		if r.Method != http.MethodPost {
@@ -186,6 +291,190 @@ func handleDeactivateActionChain(db *gorm.DB, w http.ResponseWriter, id string)
 	w.Write([]byte("Action chain deactivated successfully"))
 }
 
+func handleActionChainSubroute(db *gorm.DB, w http.ResponseWriter, r *http.Request, id, sub string) {
+	switch {
+	case sub == "runs" && r.Method == http.MethodGet:
+		handleListRuns(db, w, id)
+	case sub == "run" && r.Method == http.MethodPost:
+		handleRunActionChain(db, w, r, id)
+	case sub == "runs" || sub == "run":
+		http.Error(w, "Method not allowed", http.StatusMethodNotAllowed)
+	default:
+		http.NotFound(w, r)
+	}
+}
+
+// Run Handlers
+func handleRunActionChain(db *gorm.DB, w http.ResponseWriter, r *http.Request, id string) {
+	body, err := io.ReadAll(r.Body)
+	if err != nil {
+		http.Error(w, err.Error(), http.StatusBadRequest)
+		return
+	}
+	var payload interface{}
+	if len(strings.TrimSpace(string(body))) > 0 {
+		if err := json.Unmarshal(body, &payload); err != nil {
+			http.Error(w, "Error parsing JSON", http.StatusBadRequest)
+			return
+		}
+	}
+
+	if _, err := database.GetActionChain(db, id); err != nil {
+		http.Error(w, err.Error(), http.StatusNotFound)
+		return
+	}
+
+	run, ctx, err := database.RunActionChain(db, id, payload)
+	if errors.Is(err, models.ErrShuttingDown) {
+		http.Error(w, err.Error(), http.StatusServiceUnavailable)
+		return
+	}
+	if run == nil {
+		log.Printf("Error running action chain: %v", err)
+		http.Error(w, err.Error(), http.StatusInternalServerError)
+		return
+	}
+
+	json.NewEncoder(w).Encode(map[string]interface{}{
+		"run_id":  run.ID,
+		"status":  run.Status,
+		"error":   run.Error,
+		"results": ctx.Results,
+		"steps":   run.Steps,
+	})
+}
+
+func handleListRuns(db *gorm.DB, w http.ResponseWriter, chainID string) {
+	runs, err := database.ListRuns(db, chainID)
+	if err != nil {
+		log.Printf("Error listing runs: %v", err)
+		http.Error(w, err.Error(), http.StatusInternalServerError)
+		return
+	}
+
+	json.NewEncoder(w).Encode(runs)
+}
+
+func handleListQueues(db *gorm.DB, w http.ResponseWriter) {
+	stats, err := database.ListQueueStats(db)
+	if err != nil {
+		log.Printf("Error listing queues: %v", err)
+		http.Error(w, err.Error(), http.StatusInternalServerError)
+		return
+	}
+
+	json.NewEncoder(w).Encode(stats)
+}
+
+func handleGetRun(db *gorm.DB, w http.ResponseWriter, id string) {
+	run, err := database.GetRun(db, id)
+	if err != nil {
+		log.Printf("Error getting run: %v", err)
+		http.Error(w, err.Error(), http.StatusNotFound)
+		return
+	}
+
+	json.NewEncoder(w).Encode(run)
+}
+
+func handleListWaits(db *gorm.DB, w http.ResponseWriter) {
+	waits, err := database.ListWaits(db)
+	if err != nil {
+		log.Printf("Error listing waits: %v", err)
+		http.Error(w, err.Error(), http.StatusInternalServerError)
+		return
+	}
+
+	json.NewEncoder(w).Encode(waits)
+}
+
+func handleCancelWait(db *gorm.DB, w http.ResponseWriter, id string) {
+	wait, err := database.CancelWait(db, id)
+	switch {
+	case errors.Is(err, gorm.ErrRecordNotFound):
+		http.Error(w, err.Error(), http.StatusNotFound)
+		return
+	case errors.Is(err, models.ErrWaitNotPending):
+		http.Error(w, err.Error(), http.StatusConflict)
+		return
+	case err != nil && wait == nil:
+		log.Printf("Error cancelling wait: %v", err)
+		http.Error(w, err.Error(), http.StatusInternalServerError)
+		return
+	case err != nil:
+		log.Printf("Error cancelling run of wait %s: %v", id, err)
+	}
+
+	json.NewEncoder(w).Encode(wait)
+}
+
+func handleListApprovals(db *gorm.DB, w http.ResponseWriter) {
+	approvals, err := database.ListApprovals(db)
+	if err != nil {
+		log.Printf("Error listing approvals: %v", err)
+		http.Error(w, err.Error(), http.StatusInternalServerError)
+		return
+	}
+
+	json.NewEncoder(w).Encode(approvals)
+}
+
+func handleGetApproval(db *gorm.DB, w http.ResponseWriter, id string) {
+	approval, err := database.GetApproval(db, id)
+	if err != nil {
+		http.Error(w, err.Error(), http.StatusNotFound)
+		return
+	}
+
+	json.NewEncoder(w).Encode(approval)
+}
+
+func handleDecideApproval(db *gorm.DB, w http.ResponseWriter, r *http.Request, id string) {
+	var decision struct {
+		Decision  string `json:"decision"`
+		Comment   string `json:"comment"`
+		DecidedBy string `json:"decided_by"`
+	}
+	if err := json.NewDecoder(r.Body).Decode(&decision); err != nil {
+		http.Error(w, "Error parsing JSON", http.StatusBadRequest)
+		return
+	}
+
+	approval, err := database.DecideApproval(db, id, decision.Decision, decision.Comment, decision.DecidedBy)
+	switch {
+	case errors.Is(err, gorm.ErrRecordNotFound):
+		http.Error(w, err.Error(), http.StatusNotFound)
+		return
+	case errors.Is(err, models.ErrInvalidDecision):
+		http.Error(w, err.Error(), http.StatusBadRequest)
+		return
+	case errors.Is(err, models.ErrWaitNotPending):
+		http.Error(w, "Approval is no longer pending", http.StatusConflict)
+		return
+	case errors.Is(err, models.ErrChainNotActive):
+		http.Error(w, err.Error(), http.StatusConflict)
+		return
+	case errors.Is(err, models.ErrTooManyRuns):
+		http.Error(w, err.Error(), http.StatusTooManyRequests)
+		return
+	case errors.Is(err, models.ErrShuttingDown):
+		http.Error(w, err.Error(), http.StatusServiceUnavailable)
+		return
+	case err != nil:
+		log.Printf("Error deciding approval: %v", err)
+		http.Error(w, err.Error(), http.StatusInternalServerError)
+		return
+	}
+
+	json.NewEncoder(w).Encode(approval)
+}
+
+func handleGetStatus(w http.ResponseWriter) {
+	json.NewEncoder(w).Encode(map[string]interface{}{
+		"restore": database.GetRestoreReport(),
+	})
+}
+
 // Action Handlers
 func handleCreateAction(db *gorm.DB, w http.ResponseWriter, r *http.Request) {
 	body, err := io.ReadAll(r.Body)
@@ -5,6 +5,7 @@ go 1.22.5
 require (
 	github.com/dop251/goja v0.0.0-20240822155948-fa6d1ed5e4b6
 	github.com/joho/godotenv v1.5.1
+	golang.org/x/text v0.3.8
 	gorm.io/driver/sqlite v1.5.6
 	gorm.io/gorm v1.25.7-0.20240204074919-46816ad31dde
 )
@@ -16,5 +17,4 @@ require (
 	github.com/jinzhu/inflection v1.0.0 // indirect
 	github.com/jinzhu/now v1.1.5 // indirect
 	github.com/mattn/go-sqlite3 v1.14.22 // indirect
-	golang.org/x/text v0.3.8 // indirect
 )
@@ -5,6 +5,7 @@ import (
 	"fmt"
 	"log"
 	"sync"
+	"time"
 
 	"gorm.io/driver/sqlite"
 	"gorm.io/gorm"
@@ -12,8 +13,6 @@ import (
 	models "longboy/internal/models"
 )
 
-type chainIDKey string
-
 var (
 	actionIDCounter int
 	counterMutex    sync.Mutex
@@ -32,16 +31,29 @@ func InitDB(dbPath string) (*gorm.DB, error) {
 		return nil, err
 	}
 
+	// SQLite only supports one writer at a time, serialize access from
+	// concurrent runs instead of failing with "database is locked"
+	sqlDB, err := db.DB()
+	if err != nil {
+		return nil, err
+	}
+	sqlDB.SetMaxOpenConns(1)
+
 	// Auto Migrate the schema
-	err = db.AutoMigrate(&models.ActionChain{}, &models.Action{})
+	err = db.AutoMigrate(&models.ActionChain{}, &models.Action{}, &models.Run{}, &models.RunStep{}, &models.PollSeenItem{}, &models.QueueMessage{}, &models.RunWait{}, &models.Approval{})
 	if err != nil {
 		return nil, err
 	}
-	models.ActivationContext = context.Background()
 
 	return db, nil
 }
 
+// Shutdown stops the triggers of every active chain and lets their runs in
+// flight finish until ctx is done, cancelling the remaining ones
+func Shutdown(ctx context.Context) error {
+	return models.Runtime.Shutdown(ctx)
+}
+
 // CreateActionChain inserts a new action chain into the database
 func CreateActionChain(db *gorm.DB, chain models.ActionChain) error {
 	return db.Create(&chain).Error
@@ -74,47 +86,164 @@ func ListActionChains(db *gorm.DB) ([]models.ActionChain, error) {
 func ActivateActionChain(db *gorm.DB, id string) error {
 	log.Printf("Activating action chain with ID: %s", id)
 
-	// Set the action chain as active
-	err := db.Model(&models.ActionChain{}).Where("id = ?", id).Update("active", true).Error
-	if err != nil {
-		return fmt.Errorf("failed to activate action chain: %v", err)
-	}
-
 	var chain models.ActionChain
-	err = db.First(&chain, "id = ?", id).Error
+	err := db.First(&chain, "id = ?", id).Error
 	if err != nil {
 		return fmt.Errorf("failed to retrieve action chain: %v", err)
 	}
-	// Start the action chain in a new goroutine
-
-	ctx := &models.ActionChainContext{Results: make(map[string]interface{})}
 
-	// Keep the trigger always active
+	// Keep the trigger always active, each invocation runs with its own context
 	log.Printf("Executing trigger: %v", chain.Trigger)
-	err = chain.Trigger.Exec(ctx, db)
+	err = models.Runtime.Activate(&chain, db)
 	if err != nil {
-		log.Printf("failed to execute trigger: %v", err)
-		// Optionally, handle the error, e.g., retry, backoff, etc.
+		return fmt.Errorf("failed to start trigger: %v", err)
 	}
 
-	models.ActivationContext = context.WithValue(models.ActivationContext, chainIDKey(chain.ID), chain)
+	// Set the action chain as active
+	err = db.Model(&models.ActionChain{}).Where("id = ?", id).Update("active", true).Error
+	if err != nil {
+		models.Runtime.Deactivate(id)
+		return fmt.Errorf("failed to activate action chain: %v", err)
+	}
 
 	return nil
 }
 
-// DeactivateActionChain sets the action chain as inactive
-func DeactivateActionChain(db *gorm.DB, id string) error {
+// RestoreReport describes the outcome of re-activating chains at startup
+type RestoreReport struct {
+	CompletedAt time.Time         `json:"completed_at"`
+	Restored    []string          `json:"restored"`
+	Failed      map[string]string `json:"failed"` // chain ID -> error
+}
+
+var (
+	restoreReport RestoreReport
+	restoreMutex  sync.RWMutex
+)
+
+// RestoreActiveChains re-activates every chain persisted as active, since
+// triggers only live in memory and are lost on restart. Failures are logged
+// and kept for GetRestoreReport, the chains stay marked as active.
+func RestoreActiveChains(db *gorm.DB) (RestoreReport, error) {
+	var chains []models.ActionChain
+	if err := db.Where("active = ?", true).Find(&chains).Error; err != nil {
+		return RestoreReport{}, fmt.Errorf("failed to list active action chains: %v", err)
+	}
+
+	report := RestoreReport{Restored: []string{}, Failed: make(map[string]string)}
+	for _, chain := range chains {
+		if err := ActivateActionChain(db, chain.ID); err != nil {
+			log.Printf("Failed to restore action chain %s: %v", chain.ID, err)
+			report.Failed[chain.ID] = err.Error()
+			continue
+		}
+		report.Restored = append(report.Restored, chain.ID)
+	}
+	report.CompletedAt = time.Now()
+	log.Printf("Restored %d active action chains, %d failed", len(report.Restored), len(report.Failed))
+
+	restoreMutex.Lock()
+	restoreReport = report
+	restoreMutex.Unlock()
+
+	return report, nil
+}
+
+// GetRestoreReport returns the outcome of the last RestoreActiveChains
+func GetRestoreReport() RestoreReport {
+	restoreMutex.RLock()
+	defer restoreMutex.RUnlock()
+	return restoreReport
+}
+
+// RunActionChain executes the action chain once with the given payload, as if
+// its trigger had received it, and waits for the run to complete
+func RunActionChain(db *gorm.DB, id string, payload interface{}) (*models.Run, *models.ActionChainContext, error) {
 	var chain models.ActionChain
-	chain, ok := models.ActivationContext.Value(chainIDKey(id)).(models.ActionChain)
-	if !ok {
-		return fmt.Errorf("action chain with ID %s not found in activation context", id)
+	if err := db.First(&chain, "id = ?", id).Error; err != nil {
+		return nil, nil, fmt.Errorf("failed to retrieve action chain: %v", err)
+	}
+	return models.Runtime.RunNow(db, &chain, payload)
+}
+
+// DeactivateActionChain stops the trigger of the action chain and sets it as
+// inactive. Chains whose trigger failed to restore can still be deactivated.
+func DeactivateActionChain(db *gorm.DB, id string) error {
+	if err := models.Runtime.Deactivate(id); err != nil {
+		log.Printf("Deactivating action chain without running trigger: %v", err)
 	}
 
-	if chain.Trigger != nil && chain.Trigger.StopChan != nil {
-		close(chain.Trigger.StopChan)
+	result := db.Model(&models.ActionChain{}).Where("id = ?", id).Update("active", false)
+	if result.Error != nil {
+		return result.Error
 	}
+	if result.RowsAffected == 0 {
+		return fmt.Errorf("action chain with ID %s not found", id)
+	}
+	return nil
+}
+
+// ListRuns retrieves the run history of an action chain, most recent first
+func ListRuns(db *gorm.DB, chainID string) ([]models.Run, error) {
+	var runs []models.Run
+	err := db.Where("chain_id = ?", chainID).Order("started_at desc").Find(&runs).Error
+	return runs, err
+}
+
+// GetRun retrieves a run and its steps from the database by ID
+func GetRun(db *gorm.DB, id string) (models.Run, error) {
+	var run models.Run
+	err := db.Preload("Steps", func(db *gorm.DB) *gorm.DB {
+		return db.Order("id")
+	}).First(&run, "id = ?", id).Error
+	return run, err
+}
+
+// ListQueueStats counts the ready and in-flight messages of every queue
+func ListQueueStats(db *gorm.DB) ([]models.QueueStats, error) {
+	stats := []models.QueueStats{}
+	now := time.Now()
+	err := db.Model(&models.QueueMessage{}).
+		Select("queue, SUM(CASE WHEN visible_at <= ? THEN 1 ELSE 0 END) AS ready, SUM(CASE WHEN visible_at > ? THEN 1 ELSE 0 END) AS in_flight", now, now).
+		Group("queue").Order("queue").Scan(&stats).Error
+	return stats, err
+}
+
+// ResumeWaitingRuns resumes the runs suspended by delay and approval actions
+// as their waits end, including the ones that ended while the server was down
+func ResumeWaitingRuns(db *gorm.DB) {
+	models.Runtime.ResumeWaits(db)
+}
+
+// ListWaits retrieves the pending waits of suspended runs, the next to end first
+func ListWaits(db *gorm.DB) ([]models.RunWait, error) {
+	waits := []models.RunWait{}
+	err := db.Where("status = ?", models.WaitStatusPending).Order("resume_at IS NULL, resume_at, created_at").Find(&waits).Error
+	return waits, err
+}
+
+// CancelWait ends a pending wait and cancels the run it suspended
+func CancelWait(db *gorm.DB, id string) (*models.RunWait, error) {
+	return models.CancelWait(db, id)
+}
+
+// ListApprovals retrieves the approvals still waiting for a decision, oldest first
+func ListApprovals(db *gorm.DB) ([]models.Approval, error) {
+	approvals := []models.Approval{}
+	err := db.Where("status = ?", models.ApprovalStatusPending).Order("created_at").Find(&approvals).Error
+	return approvals, err
+}
+
+// GetApproval retrieves an approval by ID
+func GetApproval(db *gorm.DB, id string) (models.Approval, error) {
+	var approval models.Approval
+	err := db.First(&approval, "id = ?", id).Error
+	return approval, err
+}
 
-	return db.Model(&models.ActionChain{}).Where("id = ?", id).Update("active", false).Error
+// DecideApproval records a decision on a pending approval and resumes its run
+func DecideApproval(db *gorm.DB, id, decision, comment, decidedBy string) (*models.Approval, error) {
+	return models.DecideApproval(db, id, decision, comment, decidedBy)
 }
 
 // CreateAction creates a new action in the database
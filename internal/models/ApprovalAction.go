@@ -0,0 +1,264 @@
+package models
+
+import (
+	"errors"
+	"fmt"
+	"strings"
+	"time"
+
+	"gorm.io/gorm"
+)
+
+const (
+	DecisionApprove = "approve"
+	DecisionReject  = "reject"
+)
+
+const (
+	ApprovalStatusPending   = "pending"
+	ApprovalStatusDecided   = "decided"
+	ApprovalStatusExpired   = "expired"
+	ApprovalStatusCancelled = "cancelled"
+)
+
+// ErrInvalidDecision is returned when a decision is not one the approval allows
+var ErrInvalidDecision = errors.New("decision not allowed by the approval")
+
+// Approval is a decision requested from a person by an approval action. It
+// shares its ID with the wait of the suspended run.
+type Approval struct {
+	ID        string     `json:"id" gorm:"primaryKey"`
+	RunID     string     `json:"run_id" gorm:"type:varchar(100);index"`
+	ChainID   string     `json:"chain_id" gorm:"type:varchar(100);index"`
+	ActionID  string     `json:"action_id" gorm:"type:varchar(100)"`
+	Prompt    string     `json:"prompt" gorm:"type:text"`
+	Decisions []string   `json:"decisions" gorm:"serializer:json"`
+	Status    string     `json:"status" gorm:"type:varchar(20);index"`
+	Decision  string     `json:"decision,omitempty" gorm:"type:varchar(100)"`
+	Comment   string     `json:"comment,omitempty" gorm:"type:text"`
+	DecidedBy string     `json:"decided_by,omitempty" gorm:"type:varchar(100)"`
+	ExpiresAt *time.Time `json:"expires_at,omitempty"`
+	CreatedAt time.Time  `json:"created_at"`
+	DecidedAt *time.Time `json:"decided_at,omitempty"`
+}
+
+type ApprovalActionData struct {
+	Prompt          string   `json:"prompt"`            // templated text shown to the approver
+	Decisions       []string `json:"decisions"`         // allowed decisions, defaults to approve and reject
+	ExpiresIn       string   `json:"expires_in"`        // e.g. "48h", never expires if empty
+	ApproveActionID string   `json:"approve_action_id"` // next action on approve, defaults to FollowingActionID
+	RejectActionID  string   `json:"reject_action_id"`  // next action on reject, the run ends if empty
+	TimeoutActionID string   `json:"timeout_action_id"` // next action on expiry, the run fails if empty
+}
+
+func GetApprovalActionData(a *Action) (*ApprovalActionData, error) {
+	data := &ApprovalActionData{}
+	if a.Metadata["prompt"] != nil {
+		data.Prompt = a.Metadata["prompt"].(string)
+	}
+	if a.Metadata["decisions"] != nil {
+		decisions, ok := a.Metadata["decisions"].([]interface{})
+		if !ok {
+			return nil, fmt.Errorf("decisions is not a list")
+		}
+		for i, d := range decisions {
+			strValue, ok := d.(string)
+			if !ok {
+				return nil, fmt.Errorf("decision at index %d is not a string", i)
+			}
+			data.Decisions = append(data.Decisions, strValue)
+		}
+	}
+	if a.Metadata["expires_in"] != nil {
+		data.ExpiresIn = a.Metadata["expires_in"].(string)
+	}
+	if a.Metadata["approve_action_id"] != nil {
+		data.ApproveActionID = a.Metadata["approve_action_id"].(string)
+	}
+	if a.Metadata["reject_action_id"] != nil {
+		data.RejectActionID = a.Metadata["reject_action_id"].(string)
+	}
+	if a.Metadata["timeout_action_id"] != nil {
+		data.TimeoutActionID = a.Metadata["timeout_action_id"].(string)
+	}
+	return data, nil
+}
+
+func ApprovalActionDataToMetadata(data *ApprovalActionData) map[string]interface{} {
+	return map[string]interface{}{
+		"prompt":            data.Prompt,
+		"decisions":         data.Decisions,
+		"expires_in":        data.ExpiresIn,
+		"approve_action_id": data.ApproveActionID,
+		"reject_action_id":  data.RejectActionID,
+		"timeout_action_id": data.TimeoutActionID,
+	}
+}
+
+// ExecApproval suspends the run and stores a pending approval until someone
+// decides through DecideApproval or the approval expires
+func (a *Action) ExecApproval(ctx *ActionChainContext) error {
+	ap, err := GetApprovalActionData(a)
+	if err != nil {
+		return err
+	}
+	prompt, err := a.ProcessBody(ctx, ap.Prompt)
+	if err != nil {
+		return err
+	}
+	decisions := ap.Decisions
+	if len(decisions) == 0 {
+		decisions = []string{DecisionApprove, DecisionReject}
+	}
+	expiresInValue, err := a.ProcessBody(ctx, ap.ExpiresIn)
+	if err != nil {
+		return err
+	}
+	expiresIn, err := parseTimeout(expiresInValue)
+	if err != nil {
+		return err
+	}
+	var expiresAt *time.Time
+	if expiresIn > 0 {
+		t := time.Now().Add(expiresIn)
+		expiresAt = &t
+	}
+
+	return &runSuspension{
+		kind:     a.Type,
+		resumeAt: expiresAt,
+		store: func(tx *gorm.DB, w *RunWait) error {
+			return tx.Create(&Approval{
+				ID:        w.ID,
+				RunID:     w.RunID,
+				ChainID:   w.ChainID,
+				ActionID:  a.ID,
+				Prompt:    prompt,
+				Decisions: decisions,
+				Status:    ApprovalStatusPending,
+				ExpiresAt: expiresAt,
+				CreatedAt: w.CreatedAt,
+			}).Error
+		},
+	}
+}
+
+// resumeApproval stores the decision under ResultID and picks the path of the
+// run. An approval still pending when its wait ended has expired.
+func (a *Action) resumeApproval(ctx *ActionChainContext, w *RunWait, now time.Time) (string, error) {
+	ap, err := GetApprovalActionData(a)
+	if err != nil {
+		return "", err
+	}
+	var approval Approval
+	if err := ctx.db.First(&approval, "id = ?", w.ID).Error; err != nil {
+		return "", fmt.Errorf("failed to retrieve approval %s: %v", w.ID, err)
+	}
+	if approval.Status == ApprovalStatusPending {
+		result := ctx.db.Model(&Approval{}).Where("id = ? AND status = ?", approval.ID, ApprovalStatusPending).
+			Update("status", ApprovalStatusExpired)
+		if result.Error != nil {
+			return "", fmt.Errorf("failed to expire approval %s: %v", approval.ID, result.Error)
+		}
+		if result.RowsAffected == 0 {
+			return "", fmt.Errorf("approval %s changed while it expired", approval.ID)
+		}
+		approval.Status = ApprovalStatusExpired
+	}
+
+	if a.ResultID != "" {
+		result := map[string]interface{}{
+			"id":       approval.ID,
+			"status":   approval.Status,
+			"decision": approval.Decision,
+		}
+		if approval.Comment != "" {
+			result["comment"] = approval.Comment
+		}
+		if approval.DecidedBy != "" {
+			result["decided_by"] = approval.DecidedBy
+		}
+		if approval.DecidedAt != nil {
+			result["decided_at"] = approval.DecidedAt.Format(time.RFC3339Nano)
+		}
+		ctx.Set(a.ResultID, result)
+	}
+
+	switch {
+	case approval.Status == ApprovalStatusExpired:
+		if ap.TimeoutActionID == "" {
+			return "", fmt.Errorf("approval %s expired without a decision", approval.ID)
+		}
+		return ap.TimeoutActionID, nil
+	case approval.Decision == DecisionApprove && ap.ApproveActionID != "":
+		return ap.ApproveActionID, nil
+	case approval.Decision == DecisionReject:
+		return ap.RejectActionID, nil
+	default:
+		// Other decisions go on with the chain, which can branch on the result
+		return a.FollowingActionID, nil
+	}
+}
+
+// DecideApproval records the decision on a pending approval and resumes its
+// run in the background. It returns ErrInvalidDecision for a decision the
+// approval does not allow, ErrWaitNotPending once the approval ended, and
+// ErrChainNotActive or ErrTooManyRuns when the run cannot resume now.
+func DecideApproval(db *gorm.DB, id, decision, comment, decidedBy string) (*Approval, error) {
+	var approval Approval
+	if err := db.First(&approval, "id = ?", id).Error; err != nil {
+		return nil, err
+	}
+	allowed := false
+	for _, d := range approval.Decisions {
+		if d == decision {
+			allowed = true
+			break
+		}
+	}
+	if !allowed {
+		return nil, fmt.Errorf("%w: %q, expected one of %s", ErrInvalidDecision, decision, strings.Join(approval.Decisions, ", "))
+	}
+
+	// End the wait and record the decision together, so the run resumes
+	// with the decision unless an expiry or a cancellation came first
+	now := time.Now()
+	updates := map[string]interface{}{
+		"status":     ApprovalStatusDecided,
+		"decision":   decision,
+		"comment":    comment,
+		"decided_by": decidedBy,
+		"decided_at": now,
+	}
+	rt, done, err := Runtime.reserveResume(approval.ChainID)
+	if err != nil {
+		return nil, err
+	}
+	var wait *RunWait
+	err = db.Transaction(func(tx *gorm.DB) error {
+		var err error
+		if wait, err = endWait(tx, id, WaitStatusResumed); err != nil {
+			return err
+		}
+		result := tx.Model(&Approval{}).Where("id = ? AND status = ?", id, ApprovalStatusPending).Updates(updates)
+		if result.Error != nil {
+			return fmt.Errorf("failed to save approval %s: %v", id, result.Error)
+		}
+		if result.RowsAffected == 0 {
+			return ErrWaitNotPending
+		}
+		return nil
+	})
+	if err != nil {
+		done()
+		return nil, err
+	}
+	rt.resumeRun(db, wait, done)
+
+	approval.Status = ApprovalStatusDecided
+	approval.Decision = decision
+	approval.Comment = comment
+	approval.DecidedBy = decidedBy
+	approval.DecidedAt = &now
+	return &approval, nil
+}
@@ -0,0 +1,126 @@
+package models
+
+import (
+	"context"
+	"encoding/json"
+	"fmt"
+)
+
+// MaxCallDepth bounds the nesting of call_chain actions, so chains calling
+// each other in a cycle fail instead of running forever
+const MaxCallDepth = 8
+
+type CallChainActionData struct {
+	ChainID string            `json:"chain_id"` // chain to run
+	Input   string            `json:"input"`    // templated payload, stored as the called chain's trigger result
+	Output  string            `json:"output"`   // result ID of the called chain stored under ResultID
+	Outputs map[string]string `json:"outputs"`  // or several of them, keyed by name in the stored object, all results if neither is set
+}
+
+func GetCallChainActionData(a *Action) (*CallChainActionData, error) {
+	data := &CallChainActionData{}
+	if a.Metadata["chain_id"] != nil {
+		data.ChainID = a.Metadata["chain_id"].(string)
+	}
+	if a.Metadata["input"] != nil {
+		data.Input = a.Metadata["input"].(string)
+	}
+	if a.Metadata["output"] != nil {
+		data.Output = a.Metadata["output"].(string)
+	}
+	if a.Metadata["outputs"] != nil {
+		outputs, ok := a.Metadata["outputs"].(map[string]interface{})
+		if !ok {
+			return nil, fmt.Errorf("outputs is not an object")
+		}
+		data.Outputs = make(map[string]string, len(outputs))
+		for name, id := range outputs {
+			strValue, ok := id.(string)
+			if !ok {
+				return nil, fmt.Errorf("output %s is not a result ID", name)
+			}
+			data.Outputs[name] = strValue
+		}
+	}
+	return data, nil
+}
+
+func CallChainActionDataToMetadata(data *CallChainActionData) map[string]interface{} {
+	return map[string]interface{}{
+		"chain_id": data.ChainID,
+		"input":    data.Input,
+		"output":   data.Output,
+		"outputs":  data.Outputs,
+	}
+}
+
+// ExecCallChain runs another chain from its trigger's following action with
+// the templated input as its trigger result, as the body of a request for
+// webhook chains, and waits for it to complete. The called chain does not
+// need to be active. Its mapped outputs, or all its results when none are
+// mapped, are stored under the action's ResultID.
+func (a *Action) ExecCallChain(runCtx context.Context, ctx *ActionChainContext) error {
+	c, err := GetCallChainActionData(a)
+	if err != nil {
+		return err
+	}
+	if ctx.db == nil {
+		return fmt.Errorf("call_chain action requires a database")
+	}
+	if c.Output != "" && len(c.Outputs) > 0 {
+		return fmt.Errorf("call_chain action takes either output or outputs, not both")
+	}
+	if ctx.depth >= MaxCallDepth {
+		return fmt.Errorf("call depth exceeds %d, chains may be calling each other in a cycle", MaxCallDepth)
+	}
+
+	chainID, err := a.ProcessBody(ctx, c.ChainID)
+	if err != nil {
+		return err
+	}
+	var chain ActionChain
+	if err := ctx.db.First(&chain, "id = ?", chainID).Error; err != nil {
+		return fmt.Errorf("failed to retrieve action chain %s: %v", chainID, err)
+	}
+	if chain.Trigger == nil {
+		return fmt.Errorf("action chain %s has no trigger", chain.ID)
+	}
+	input, err := a.ProcessBody(ctx, c.Input)
+	if err != nil {
+		return err
+	}
+	var payload interface{} = input
+	var jsonData interface{}
+	if json.Unmarshal([]byte(input), &jsonData) == nil {
+		payload = jsonData
+	}
+
+	payload = chain.Trigger.runPayload(payload)
+	called := NewRunContext(chain.Context)
+	called.depth = ctx.depth + 1
+	called.hops = ctx.hops
+	called.Set(chain.Trigger.ResultID, payload)
+	run, err := StartRun(runCtx, ctx.db, &chain, called, chain.Trigger.FollowingActionID, payload)
+	if err != nil {
+		if run != nil {
+			return fmt.Errorf("run %s of chain %s failed: %v", run.ID, chain.ID, err)
+		}
+		return err
+	}
+
+	if a.ResultID == "" {
+		return nil
+	}
+	if len(c.Outputs) > 0 {
+		outputs := make(map[string]interface{}, len(c.Outputs))
+		for name, id := range c.Outputs {
+			outputs[name] = called.Get(id)
+		}
+		ctx.Set(a.ResultID, outputs)
+	} else if c.Output != "" {
+		ctx.Set(a.ResultID, called.Get(c.Output))
+	} else {
+		ctx.Set(a.ResultID, NewRunContext(called).Results)
+	}
+	return nil
+}
@@ -0,0 +1,148 @@
+package models
+
+import (
+	"errors"
+	"fmt"
+	"log"
+	"sync"
+	"time"
+
+	"gorm.io/gorm"
+)
+
+// MaxChainHops bounds how many runs in a row chain triggers start from each
+// other, so chains triggering each other in a cycle stop instead of running
+// forever
+const MaxChainHops = 8
+
+const (
+	ChainOutcomeSuccess = "success" // succeeded or recovered runs
+	ChainOutcomeFailure = "failure" // failed or cancelled runs
+	ChainOutcomeAny     = "any"
+)
+
+// ChainCompletionConfig configures a "chain" trigger: each completed run of
+// the upstream chain with a matching outcome starts a run, with the upstream
+// run and its final results as the trigger result
+type ChainCompletionConfig struct {
+	ChainID string `json:"chain_id"`     // upstream chain
+	On      string `json:"on,omitempty"` // "success" (default), "failure" or "any"
+}
+
+// RunCompletedEvent is published when a run of any chain completes
+type RunCompletedEvent struct {
+	ChainID   string
+	RunID     string
+	Status    string
+	Error     string
+	StartedAt time.Time
+	EndedAt   time.Time
+	Hops      int                    // runs in a row started by chain triggers that led to this run
+	Results   map[string]interface{} // copy of the run's final context
+}
+
+var (
+	runSubscribers      = make(map[int]func(RunCompletedEvent))
+	runSubscribersNext  int
+	runSubscribersMutex sync.RWMutex
+)
+
+// SubscribeRunCompleted calls fn after every run completes, until the returned
+// function is called. fn runs on the goroutine of the completed run.
+func SubscribeRunCompleted(fn func(RunCompletedEvent)) (unsubscribe func()) {
+	runSubscribersMutex.Lock()
+	defer runSubscribersMutex.Unlock()
+	id := runSubscribersNext
+	runSubscribersNext++
+	runSubscribers[id] = fn
+	return func() {
+		runSubscribersMutex.Lock()
+		defer runSubscribersMutex.Unlock()
+		delete(runSubscribers, id)
+	}
+}
+
+func publishRunCompleted(run *Run, ctx *ActionChainContext) {
+	runSubscribersMutex.RLock()
+	defer runSubscribersMutex.RUnlock()
+	if len(runSubscribers) == 0 {
+		return
+	}
+	event := RunCompletedEvent{
+		ChainID:   run.ChainID,
+		RunID:     run.ID,
+		Status:    run.Status,
+		Error:     run.Error,
+		StartedAt: run.StartedAt,
+		Hops:      run.Hops,
+		Results:   NewRunContext(ctx).Results,
+	}
+	if run.EndedAt != nil {
+		event.EndedAt = *run.EndedAt
+	}
+	for _, fn := range runSubscribers {
+		fn(event)
+	}
+}
+
+// matches reports whether the outcome of a run is one the trigger fires on
+func (cfg *ChainCompletionConfig) matches(status string) bool {
+	success := status == RunStatusSucceeded || status == RunStatusRecovered
+	switch cfg.On {
+	case "", ChainOutcomeSuccess:
+		return success
+	case ChainOutcomeFailure:
+		return !success
+	default:
+		return true
+	}
+}
+
+// execChain starts a run whenever the upstream chain completes
+func (t *Trigger) execChain(rt *ChainRuntime, db *gorm.DB) (stop func(), err error) {
+	cfg := t.Chain
+	if cfg == nil || cfg.ChainID == "" {
+		return nil, fmt.Errorf("chain trigger requires an upstream chain_id")
+	}
+	if cfg.ChainID == rt.chain.ID {
+		return nil, fmt.Errorf("chain trigger of %s cannot listen to its own runs", rt.chain.ID)
+	}
+	switch cfg.On {
+	case "", ChainOutcomeSuccess, ChainOutcomeFailure, ChainOutcomeAny:
+	default:
+		return nil, fmt.Errorf("invalid chain trigger outcome %q, expected success, failure or any", cfg.On)
+	}
+
+	on := cfg.On
+	if on == "" {
+		on = ChainOutcomeSuccess
+	}
+	rt.SetEndpoint(fmt.Sprintf("on %s of chain %s", on, cfg.ChainID))
+	fmt.Printf("Listening for completed runs of chain %s for action chain %s...\n", cfg.ChainID, rt.chain.ID)
+
+	unsubscribe := SubscribeRunCompleted(func(event RunCompletedEvent) {
+		if event.ChainID != cfg.ChainID || !cfg.matches(event.Status) {
+			return
+		}
+		hops := event.Hops + 1
+		if hops > MaxChainHops {
+			log.Printf("Skipping run of chain %s after run %s of %s: %d runs in a row started by chain triggers", rt.chain.ID, event.RunID, event.ChainID, MaxChainHops)
+			rt.RecordError(fmt.Errorf("run after %s of chain %s skipped: more than %d runs in a row started by chain triggers, chains may be triggering each other in a cycle", event.RunID, event.ChainID, MaxChainHops))
+			return
+		}
+		payload := map[string]interface{}{
+			"chain_id":   event.ChainID,
+			"run_id":     event.RunID,
+			"status":     event.Status,
+			"error":      event.Error,
+			"started_at": event.StartedAt.Format(time.RFC3339Nano),
+			"ended_at":   event.EndedAt.Format(time.RFC3339Nano),
+			"results":    event.Results,
+		}
+		if err := rt.fire(db, payload, hops); errors.Is(err, ErrTooManyRuns) {
+			log.Printf("Skipping run of chain %s after run %s of %s: too many concurrent runs", rt.chain.ID, event.RunID, event.ChainID)
+			rt.RecordError(fmt.Errorf("run after %s of chain %s skipped: too many concurrent runs", event.RunID, event.ChainID))
+		}
+	})
+	return unsubscribe, nil
+}
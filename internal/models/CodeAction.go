@@ -1,6 +1,7 @@
 package models
 
 import (
+	"context"
 	"fmt"
 	"os/exec"
 	"strings"
@@ -36,7 +37,7 @@ POC —
 Need refactoring to avoid using exec.Commands inside the Longboy container
 -> Maybe using a pakcage like Goja (github.com/dop251/goja)
 */
-func (a *Action) ExecCode(ctx *ActionChainContext) error {
+func (a *Action) ExecCode(runCtx context.Context, ctx *ActionChainContext) error {
 	c, err := GetCodeActionData(a)
 	if err != nil {
 		return err
@@ -50,18 +51,24 @@ func (a *Action) ExecCode(ctx *ActionChainContext) error {
 	var output strings.Builder
 	switch c.Language {
 	case "python":
-		cmd := exec.Command("python", "-c", sc)
+		cmd := exec.CommandContext(runCtx, "python", "-c", sc)
 		var byteOutput []byte
 		byteOutput, err = cmd.CombinedOutput()
 		output.Write(byteOutput)
 	case "bash":
-		cmd := exec.Command("bash", "-c", sc)
+		cmd := exec.CommandContext(runCtx, "bash", "-c", sc)
 		var byteOutput []byte
 		byteOutput, err = cmd.CombinedOutput()
 		output.Write(byteOutput)
 	case "javascript":
 		vm := goja.New()
 
+		// Abort the script when the run is cancelled or times out
+		stop := context.AfterFunc(runCtx, func() {
+			vm.Interrupt(runCtx.Err())
+		})
+		defer stop()
+
 		// Provide a custom console.log implementation
 		console := vm.NewObject()
 		err = console.Set("log", func(call goja.FunctionCall) goja.Value {
@@ -92,7 +99,7 @@ func (a *Action) ExecCode(ctx *ActionChainContext) error {
 	}
 
 	if a.ResultID != "" {
-		ctx.Results[a.ResultID] = output.String()
+		ctx.Set(a.ResultID, output.String())
 	}
 
 	return nil
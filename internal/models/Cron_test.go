@@ -0,0 +1,99 @@
+package models
+
+import (
+	"testing"
+	"time"
+)
+
+func TestParseCronErrors(t *testing.T) {
+	tests := []struct {
+		name string
+		expr string
+	}{
+		{"empty", ""},
+		{"too few fields", "* * * *"},
+		{"too many fields", "* * * * * * *"},
+		{"second out of range", "60 * * * * *"},
+		{"month out of range", "0 0 0 1 13 *"},
+		{"day of month zero", "0 0 0 0 * *"},
+		{"day of week out of range", "0 0 0 * * 8"},
+		{"zero step", "*/0 * * * * *"},
+		{"negative step", "*/-5 * * * * *"},
+		{"reversed range", "0 0 5-1 * * *"},
+		{"unknown name", "0 0 0 * foo *"},
+		{"not a number", "a * * * * *"},
+		{"every too short", "@every 500ms"},
+		{"every invalid", "@every soon"},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			if _, err := parseCron(tt.expr); err == nil {
+				t.Errorf("parseCron(%q) succeeded, want an error", tt.expr)
+			}
+		})
+	}
+}
+
+func TestCronNext(t *testing.T) {
+	newYork, err := time.LoadLocation("America/New_York")
+	if err != nil {
+		t.Skipf("time zone data unavailable: %v", err)
+	}
+	utc := func(value string) time.Time {
+		parsed, err := time.Parse(time.RFC3339Nano, value)
+		if err != nil {
+			t.Fatal(err)
+		}
+		return parsed
+	}
+	local := func(value string) time.Time {
+		parsed, err := time.ParseInLocation("2006-01-02 15:04:05", value, newYork)
+		if err != nil {
+			t.Fatal(err)
+		}
+		return parsed
+	}
+
+	tests := []struct {
+		name string
+		expr string
+		from time.Time
+		want time.Time
+	}{
+		{"every minute", "0 * * * * *", utc("2026-10-16T10:00:30Z"), utc("2026-10-16T10:01:00Z")},
+		{"strictly after", "0 * * * * *", utc("2026-10-16T10:01:00Z"), utc("2026-10-16T10:02:00Z")},
+		{"five fields fire at second 0", "*/15 * * * *", utc("2026-10-16T10:07:12Z"), utc("2026-10-16T10:15:00Z")},
+		{"minute step wraps the hour", "0 */20 * * * *", utc("2026-10-16T10:45:00Z"), utc("2026-10-16T11:00:00Z")},
+		{"range with step", "0 0 8-18/5 * * *", utc("2026-10-16T10:00:00Z"), utc("2026-10-16T13:00:00Z")},
+		{"list", "0 0 9,17 * * *", utc("2026-10-16T09:30:00Z"), utc("2026-10-16T17:00:00Z")},
+		{"weekdays skip the weekend", "0 30 9 * * mon-fri", utc("2026-10-16T10:00:00Z"), utc("2026-10-19T09:30:00Z")},
+		{"sunday written 7", "0 0 0 * * 7", utc("2026-01-01T00:00:00Z"), utc("2026-01-04T00:00:00Z")},
+		{"month names", "0 0 0 1 jun,dec *", utc("2026-06-02T00:00:00Z"), utc("2026-12-01T00:00:00Z")},
+		{"day of month alone", "0 0 0 13 * *", utc("2026-01-01T00:00:00Z"), utc("2026-01-13T00:00:00Z")},
+		{"day of month or day of week", "0 0 0 13 * fri", utc("2026-01-01T00:00:00Z"), utc("2026-01-02T00:00:00Z")},
+		{"leap day", "0 0 0 29 2 *", utc("2026-03-01T00:00:00Z"), utc("2028-02-29T00:00:00Z")},
+		{"never matches", "0 0 0 31 2 *", utc("2026-01-01T00:00:00Z"), time.Time{}},
+		{"daily descriptor", "@daily", utc("2026-03-10T15:00:00Z"), utc("2026-03-11T00:00:00Z")},
+		{"hourly descriptor", "@hourly", utc("2026-03-10T15:00:00Z"), utc("2026-03-10T16:00:00Z")},
+		{"every drops fractions of a second", "@every 90s", utc("2026-10-16T10:00:00.5Z"), utc("2026-10-16T10:01:30Z")},
+		{"keeps the location", "0 0 9 * * *", local("2026-10-16 10:00:00"), local("2026-10-17 09:00:00")},
+		{"time skipped by DST moves to the next day", "0 30 2 * * *", local("2026-03-08 00:00:00"), local("2026-03-09 02:30:00")},
+		{"hourly across DST start", "0 0 * * * *", local("2026-03-08 01:30:00"), local("2026-03-08 03:00:00")},
+		{"first occurrence at DST end", "0 30 1 * * *", local("2026-11-01 00:00:00"), time.Date(2026, 11, 1, 5, 30, 0, 0, time.UTC)},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			s, err := parseCron(tt.expr)
+			if err != nil {
+				t.Fatalf("parseCron(%q): %v", tt.expr, err)
+			}
+			got := s.next(tt.from)
+			if !got.Equal(tt.want) {
+				t.Errorf("next(%s) = %s, want %s", tt.from, got, tt.want)
+			}
+			if !got.IsZero() && got.Location() != tt.from.Location() {
+				t.Errorf("next(%s) is in %s, want %s", tt.from, got.Location(), tt.from.Location())
+			}
+		})
+	}
+}
@@ -0,0 +1,87 @@
+package models
+
+import (
+	"fmt"
+	"time"
+)
+
+type DelayActionData struct {
+	Duration string `json:"duration"` // how long to wait, e.g. "72h"
+	Until    string `json:"until"`    // or a templated RFC 3339 timestamp to wait for
+}
+
+func GetDelayActionData(a *Action) (*DelayActionData, error) {
+	data := &DelayActionData{}
+	if a.Metadata["duration"] != nil {
+		data.Duration = a.Metadata["duration"].(string)
+	}
+	if a.Metadata["until"] != nil {
+		data.Until = a.Metadata["until"].(string)
+	}
+	return data, nil
+}
+
+func DelayActionDataToMetadata(data *DelayActionData) map[string]interface{} {
+	return map[string]interface{}{
+		"duration": data.Duration,
+		"until":    data.Until,
+	}
+}
+
+// ExecDelay suspends the run until the delay is over. The wait is stored so
+// the run resumes even after a restart. A time already passed does not wait.
+func (a *Action) ExecDelay(ctx *ActionChainContext) error {
+	d, err := GetDelayActionData(a)
+	if err != nil {
+		return err
+	}
+	if (d.Duration == "") == (d.Until == "") {
+		return fmt.Errorf("delay action requires either duration or until")
+	}
+
+	now := time.Now()
+	var resumeAt time.Time
+	if d.Duration != "" {
+		value, err := a.ProcessBody(ctx, d.Duration)
+		if err != nil {
+			return err
+		}
+		duration, err := time.ParseDuration(value)
+		if err != nil {
+			return fmt.Errorf("invalid duration %q: %v", value, err)
+		}
+		resumeAt = now.Add(duration)
+	} else {
+		value, err := a.ProcessBody(ctx, d.Until)
+		if err != nil {
+			return err
+		}
+		if resumeAt, err = time.Parse(time.RFC3339, value); err != nil {
+			return fmt.Errorf("invalid timestamp %q: %v", value, err)
+		}
+	}
+
+	if !resumeAt.After(now) {
+		a.setDelayResult(ctx, resumeAt, now)
+		return nil
+	}
+	return &runSuspension{kind: a.Type, resumeAt: &resumeAt}
+}
+
+func (a *Action) resumeDelay(ctx *ActionChainContext, w *RunWait, now time.Time) (string, error) {
+	scheduledAt := now
+	if w.ResumeAt != nil {
+		scheduledAt = *w.ResumeAt
+	}
+	a.setDelayResult(ctx, scheduledAt, now)
+	return a.FollowingActionID, nil
+}
+
+func (a *Action) setDelayResult(ctx *ActionChainContext, scheduledAt, resumedAt time.Time) {
+	if a.ResultID != "" {
+		ctx.Set(a.ResultID, map[string]interface{}{
+			"scheduled_at": scheduledAt.Format(time.RFC3339Nano),
+			"resumed_at":   resumedAt.Format(time.RFC3339Nano),
+		})
+	}
+}
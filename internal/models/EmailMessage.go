@@ -0,0 +1,197 @@
+package models
+
+import (
+	"bytes"
+	"encoding/base64"
+	"fmt"
+	"io"
+	"mime"
+	"mime/multipart"
+	"mime/quotedprintable"
+	"net/mail"
+	"net/textproto"
+	"os"
+	"path/filepath"
+	"strings"
+	"time"
+
+	"golang.org/x/text/encoding/htmlindex"
+)
+
+// emailParser collects the bodies and attachments of a MIME message
+type emailParser struct {
+	attachmentDir string // directory of this message's attachments, created on the first one
+	text          strings.Builder
+	html          strings.Builder
+	attachments   []interface{}
+}
+
+// parseEmail parses a raw RFC 5322 message into the trigger result of an
+// email trigger. Attachments are saved in attachmentDir, created on the first
+// one.
+func parseEmail(data []byte, attachmentDir string) (map[string]interface{}, error) {
+	msg, err := mail.ReadMessage(bytes.NewReader(data))
+	if err != nil {
+		return nil, fmt.Errorf("failed to parse message: %v", err)
+	}
+
+	decoder := &mime.WordDecoder{CharsetReader: charsetReader}
+	decode := func(value string) string {
+		if decoded, err := decoder.DecodeHeader(value); err == nil {
+			return decoded
+		}
+		return value
+	}
+	headers := make(map[string]interface{}, len(msg.Header))
+	for name, values := range msg.Header {
+		decoded := make([]string, len(values))
+		for i, v := range values {
+			decoded[i] = decode(v)
+		}
+		headers[name] = strings.Join(decoded, ", ")
+	}
+
+	p := &emailParser{attachmentDir: attachmentDir}
+	if err := p.walk(textproto.MIMEHeader(msg.Header), msg.Body); err != nil {
+		return nil, err
+	}
+
+	email := map[string]interface{}{
+		"message_id":  strings.Trim(msg.Header.Get("Message-Id"), "<>"),
+		"subject":     decode(msg.Header.Get("Subject")),
+		"from":        "",
+		"from_name":   "",
+		"to":          addressList(msg.Header, "To"),
+		"cc":          addressList(msg.Header, "Cc"),
+		"reply_to":    addressList(msg.Header, "Reply-To"),
+		"date":        msg.Header.Get("Date"),
+		"headers":     headers,
+		"text":        p.text.String(),
+		"html":        p.html.String(),
+		"attachments": p.attachments,
+	}
+	if from, err := mail.ParseAddress(msg.Header.Get("From")); err == nil {
+		email["from"] = from.Address
+		email["from_name"] = from.Name
+	} else {
+		email["from"] = decode(msg.Header.Get("From"))
+	}
+	if date, err := msg.Header.Date(); err == nil {
+		email["date"] = date.Format(time.RFC3339)
+	}
+	return email, nil
+}
+
+// walk adds a MIME entity to the parser, descending into multipart entities
+func (p *emailParser) walk(header textproto.MIMEHeader, body io.Reader) error {
+	mediaType, params, err := mime.ParseMediaType(header.Get("Content-Type"))
+	if err != nil {
+		mediaType, params = "text/plain", nil
+	}
+
+	if strings.HasPrefix(mediaType, "multipart/") {
+		reader := multipart.NewReader(body, params["boundary"])
+		for {
+			part, err := reader.NextRawPart()
+			if err == io.EOF {
+				return nil
+			}
+			if err != nil {
+				return fmt.Errorf("failed to parse multipart message: %v", err)
+			}
+			if err := p.walk(part.Header, part); err != nil {
+				return err
+			}
+		}
+	}
+
+	content, err := io.ReadAll(transferDecoder(header.Get("Content-Transfer-Encoding"), body))
+	if err != nil {
+		return fmt.Errorf("failed to decode %s part: %v", mediaType, err)
+	}
+
+	disposition, dispositionParams, _ := mime.ParseMediaType(header.Get("Content-Disposition"))
+	filename := dispositionParams["filename"]
+	if filename == "" {
+		filename = params["name"]
+	}
+	if disposition != "attachment" && filename == "" {
+		switch mediaType {
+		case "text/plain":
+			p.text.WriteString(decodeCharset(params["charset"], content))
+			return nil
+		case "text/html":
+			p.html.WriteString(decodeCharset(params["charset"], content))
+			return nil
+		}
+	}
+	return p.saveAttachment(filename, mediaType, content)
+}
+
+func (p *emailParser) saveAttachment(filename, contentType string, content []byte) error {
+	// Never let the sender choose where the file is written
+	filename = filepath.Base(filepath.Clean("/" + filename))
+	if filename == "/" || filename == "." {
+		filename = fmt.Sprintf("attachment-%d", len(p.attachments)+1)
+	}
+	if err := os.MkdirAll(p.attachmentDir, 0o755); err != nil {
+		return fmt.Errorf("failed to save attachment: %v", err)
+	}
+	path := filepath.Join(p.attachmentDir, fmt.Sprintf("%d-%s", len(p.attachments)+1, filename))
+	if err := os.WriteFile(path, content, 0o644); err != nil {
+		return fmt.Errorf("failed to save attachment: %v", err)
+	}
+	p.attachments = append(p.attachments, map[string]interface{}{
+		"filename":     filename,
+		"content_type": contentType,
+		"size":         len(content),
+		"path":         path,
+	})
+	return nil
+}
+
+func transferDecoder(encoding string, body io.Reader) io.Reader {
+	switch strings.ToLower(strings.TrimSpace(encoding)) {
+	case "base64":
+		return base64.NewDecoder(base64.StdEncoding, body)
+	case "quoted-printable":
+		return quotedprintable.NewReader(body)
+	default:
+		return body
+	}
+}
+
+// decodeCharset converts the content of a text part to UTF-8. Bytes that are
+// not valid in the charset, or in UTF-8 when it is unknown, become U+FFFD.
+func decodeCharset(charset string, content []byte) string {
+	if charset != "" {
+		if enc, err := htmlindex.Get(charset); err == nil {
+			if decoded, err := enc.NewDecoder().Bytes(content); err == nil {
+				content = decoded
+			}
+		}
+	}
+	return strings.ToValidUTF8(string(content), "\uFFFD")
+}
+
+// charsetReader converts encoded words of headers in other charsets than
+// UTF-8, US-ASCII and ISO-8859-1 to UTF-8
+func charsetReader(charset string, input io.Reader) (io.Reader, error) {
+	enc, err := htmlindex.Get(charset)
+	if err != nil {
+		return nil, fmt.Errorf("unsupported charset %s: %v", charset, err)
+	}
+	return enc.NewDecoder().Reader(input), nil
+}
+
+func addressList(header mail.Header, name string) []interface{} {
+	list := []interface{}{}
+	addresses, err := header.AddressList(name)
+	if err != nil {
+		return list
+	}
+	for _, address := range addresses {
+		list = append(list, address.Address)
+	}
+	return list
+}
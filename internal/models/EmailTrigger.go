@@ -0,0 +1,282 @@
+package models
+
+import (
+	"errors"
+	"fmt"
+	"io"
+	"log"
+	"net"
+	"net/textproto"
+	"os"
+	"path/filepath"
+	"strings"
+	"sync"
+	"time"
+
+	"longboy/internal/utils"
+
+	"gorm.io/gorm"
+)
+
+const (
+	// defaultMaxMessageSize is used when an email trigger does not set a limit
+	defaultMaxMessageSize = 10 << 20
+	// smtpCommandTimeout bounds how long a client may stay idle
+	smtpCommandTimeout = 5 * time.Minute
+)
+
+// EmailConfig configures an "email" trigger: a minimal SMTP server receiving
+// messages for the configured recipients, each message starts a run with the
+// parsed email as the trigger result
+type EmailConfig struct {
+	Addr           string   `json:"addr"`                       // listen address, e.g. "127.0.0.1:2525"
+	Recipients     []string `json:"recipients"`                 // accepted addresses, or "@example.com" for a whole domain
+	AttachmentDir  string   `json:"attachment_dir,omitempty"`   // where attachments are saved, defaults to a "longboy-attachments" temporary directory
+	MaxMessageSize int64    `json:"max_message_size,omitempty"` // in bytes, defaults to 10MB
+	Hostname       string   `json:"hostname,omitempty"`         // announced in the greeting, defaults to "longboy"
+}
+
+// execEmail listens for SMTP connections on the configured address
+func (t *Trigger) execEmail(rt *ChainRuntime, db *gorm.DB) (stop func(), err error) {
+	cfg := t.Email
+	if cfg == nil || cfg.Addr == "" {
+		return nil, fmt.Errorf("email trigger requires a listen address")
+	}
+	if len(cfg.Recipients) == 0 {
+		return nil, fmt.Errorf("email trigger requires at least one recipient")
+	}
+
+	listener, err := net.Listen("tcp", cfg.Addr)
+	if err != nil {
+		return nil, fmt.Errorf("failed to listen on %s: %v", cfg.Addr, err)
+	}
+	rt.SetEndpoint(fmt.Sprintf("smtp://%s for %s", listener.Addr(), strings.Join(cfg.Recipients, ", ")))
+	fmt.Printf("Listening for email on %s...\n", listener.Addr())
+
+	server := &smtpServer{
+		cfg:   cfg,
+		rt:    rt,
+		db:    db,
+		conns: make(map[net.Conn]struct{}),
+	}
+	go server.serve(listener)
+
+	return func() {
+		listener.Close()
+		server.closeConns()
+	}, nil
+}
+
+type smtpServer struct {
+	cfg *EmailConfig
+	rt  *ChainRuntime
+	db  *gorm.DB
+
+	mu    sync.Mutex
+	conns map[net.Conn]struct{}
+}
+
+func (s *smtpServer) serve(listener net.Listener) {
+	for {
+		conn, err := listener.Accept()
+		if err != nil {
+			if !errors.Is(err, net.ErrClosed) {
+				log.Printf("SMTP accept on %s: %v", s.cfg.Addr, err)
+				s.rt.RecordError(err)
+			}
+			return
+		}
+		s.mu.Lock()
+		s.conns[conn] = struct{}{}
+		s.mu.Unlock()
+		go func() {
+			defer func() {
+				s.mu.Lock()
+				delete(s.conns, conn)
+				s.mu.Unlock()
+				conn.Close()
+			}()
+			s.handle(conn)
+		}()
+	}
+}
+
+func (s *smtpServer) closeConns() {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	for conn := range s.conns {
+		conn.Close()
+	}
+}
+
+// handle runs one SMTP session, see RFC 5321 for the commands
+func (s *smtpServer) handle(conn net.Conn) {
+	text := textproto.NewConn(conn)
+	hostname := s.cfg.Hostname
+	if hostname == "" {
+		hostname = "longboy"
+	}
+	maxSize := s.cfg.MaxMessageSize
+	if maxSize <= 0 {
+		maxSize = defaultMaxMessageSize
+	}
+
+	reply := func(code int, msg string) bool {
+		return text.PrintfLine("%d %s", code, msg) == nil
+	}
+
+	var mailFrom string
+	var rcptTo []string
+	greeted := false
+	if !reply(220, hostname+" ESMTP ready") {
+		return
+	}
+	for {
+		conn.SetDeadline(time.Now().Add(smtpCommandTimeout))
+		line, err := text.ReadLine()
+		if err != nil {
+			return
+		}
+		verb, arg, _ := strings.Cut(line, " ")
+		arg = strings.TrimSpace(arg)
+
+		switch strings.ToUpper(verb) {
+		case "HELO":
+			greeted = true
+			reply(250, hostname)
+		case "EHLO":
+			greeted = true
+			text.PrintfLine("250-%s", hostname)
+			text.PrintfLine("250-SIZE %d", maxSize)
+			reply(250, "8BITMIME")
+		case "MAIL":
+			if !greeted {
+				reply(503, "Send HELO or EHLO first")
+				continue
+			}
+			address, ok := smtpPath(arg, "FROM:")
+			if !ok {
+				reply(501, "Syntax: MAIL FROM:<address>")
+				continue
+			}
+			mailFrom, rcptTo = address, nil
+			reply(250, "OK")
+		case "RCPT":
+			if mailFrom == "" {
+				reply(503, "Send MAIL first")
+				continue
+			}
+			address, ok := smtpPath(arg, "TO:")
+			if !ok || address == "" {
+				reply(501, "Syntax: RCPT TO:<address>")
+				continue
+			}
+			if !s.accepts(address) {
+				reply(550, "No such recipient here")
+				continue
+			}
+			rcptTo = append(rcptTo, address)
+			reply(250, "OK")
+		case "DATA":
+			if len(rcptTo) == 0 {
+				reply(503, "Send RCPT first")
+				continue
+			}
+			reply(354, "End data with <CR><LF>.<CR><LF>")
+			dot := text.DotReader()
+			data, err := io.ReadAll(io.LimitReader(dot, maxSize+1))
+			if err != nil {
+				return
+			}
+			if int64(len(data)) > maxSize {
+				// Drain the rest of the message before answering
+				io.Copy(io.Discard, dot)
+				reply(552, "Message exceeds the maximum size")
+			} else {
+				code, msg := s.deliver(mailFrom, rcptTo, data)
+				reply(code, msg)
+			}
+			mailFrom, rcptTo = "", nil
+		case "RSET":
+			mailFrom, rcptTo = "", nil
+			reply(250, "OK")
+		case "NOOP":
+			reply(250, "OK")
+		case "VRFY":
+			reply(252, "Cannot verify user")
+		case "QUIT":
+			reply(221, "Bye")
+			return
+		default:
+			reply(502, "Command not implemented")
+		}
+	}
+}
+
+// deliver parses a received message and starts a run with it. The
+// attachments of messages that start no run are removed.
+func (s *smtpServer) deliver(mailFrom string, rcptTo []string, data []byte) (int, string) {
+	dir := s.cfg.AttachmentDir
+	if dir == "" {
+		dir = filepath.Join(os.TempDir(), "longboy-attachments")
+	}
+	attachmentDir := filepath.Join(dir, s.rt.chain.ID, utils.NewID())
+	removeAttachments := func() {
+		if err := os.RemoveAll(attachmentDir); err != nil {
+			log.Printf("Failed to remove attachments of an email for chain %s: %v", s.rt.chain.ID, err)
+		}
+	}
+	email, err := parseEmail(data, attachmentDir)
+	if err != nil {
+		removeAttachments()
+		log.Printf("Rejected email for chain %s: %v", s.rt.chain.ID, err)
+		return 554, "Invalid message"
+	}
+	email["envelope"] = map[string]interface{}{
+		"mail_from": mailFrom,
+		"rcpt_to":   stringsToList(rcptTo),
+	}
+
+	err = s.rt.Fire(s.db, email)
+	if err != nil {
+		removeAttachments()
+	}
+	if errors.Is(err, ErrTooManyRuns) {
+		return 451, "Too many concurrent runs, try again later"
+	}
+	return 250, "OK: message accepted"
+}
+
+// accepts reports whether mail for address is accepted, recipients starting
+// with "@" accept a whole domain
+func (s *smtpServer) accepts(address string) bool {
+	address = strings.ToLower(address)
+	for _, recipient := range s.cfg.Recipients {
+		recipient = strings.ToLower(recipient)
+		if address == recipient || (strings.HasPrefix(recipient, "@") && strings.HasSuffix(address, recipient)) {
+			return true
+		}
+	}
+	return false
+}
+
+// smtpPath extracts the address of "FROM:<a@b.c> SIZE=123" style arguments
+func smtpPath(arg, prefix string) (string, bool) {
+	if len(arg) < len(prefix) || !strings.EqualFold(arg[:len(prefix)], prefix) {
+		return "", false
+	}
+	path := strings.TrimSpace(arg[len(prefix):])
+	path, _, _ = strings.Cut(path, " ")
+	if !strings.HasPrefix(path, "<") || !strings.HasSuffix(path, ">") {
+		return "", false
+	}
+	return path[1 : len(path)-1], true
+}
+
+func stringsToList(values []string) []interface{} {
+	list := make([]interface{}, len(values))
+	for i, v := range values {
+		list[i] = v
+	}
+	return list
+}
@@ -0,0 +1,72 @@
+package models
+
+import (
+	"encoding/json"
+	"fmt"
+)
+
+type EnqueueActionData struct {
+	Queue   string `json:"queue"`
+	Message string `json:"message"`
+	Delay   string `json:"delay"`
+}
+
+func GetEnqueueActionData(a *Action) (*EnqueueActionData, error) {
+	data := &EnqueueActionData{}
+	if a.Metadata["queue"] != nil {
+		data.Queue = a.Metadata["queue"].(string)
+	}
+	if a.Metadata["message"] != nil {
+		data.Message = a.Metadata["message"].(string)
+	}
+	if a.Metadata["delay"] != nil {
+		data.Delay = a.Metadata["delay"].(string)
+	}
+	return data, nil
+}
+
+func EnqueueActionDataToMetadata(data *EnqueueActionData) map[string]interface{} {
+	return map[string]interface{}{
+		"queue":   data.Queue,
+		"message": data.Message,
+		"delay":   data.Delay,
+	}
+}
+
+// ExecEnqueue pushes the templated message on a queue of the internal job
+// queue, messages that are valid JSON are stored as such
+func (a *Action) ExecEnqueue(ctx *ActionChainContext) error {
+	e, err := GetEnqueueActionData(a)
+	if err != nil {
+		return err
+	}
+	if ctx.db == nil {
+		return fmt.Errorf("enqueue action requires a database")
+	}
+	queue, err := a.ProcessBody(ctx, e.Queue)
+	if err != nil {
+		return err
+	}
+	message, err := a.ProcessBody(ctx, e.Message)
+	if err != nil {
+		return err
+	}
+	delay, err := parseTimeout(e.Delay)
+	if err != nil {
+		return err
+	}
+
+	var body interface{} = message
+	var jsonData interface{}
+	if json.Unmarshal([]byte(message), &jsonData) == nil {
+		body = jsonData
+	}
+	msg, err := Enqueue(ctx.db, queue, body, delay)
+	if err != nil {
+		return err
+	}
+	if a.ResultID != "" {
+		ctx.Set(a.ResultID, msg.ID)
+	}
+	return nil
+}
@@ -0,0 +1,219 @@
+package models
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"io/fs"
+	"log"
+	"net/http"
+	"os"
+	"path/filepath"
+	"strings"
+	"time"
+	"unicode/utf8"
+
+	"gorm.io/gorm"
+)
+
+const (
+	// defaultFileScanInterval is used when a file trigger does not set an interval
+	defaultFileScanInterval = 2 * time.Second
+	// defaultMaxContentSize is the largest text file whose content is put in
+	// the context, larger and binary files are passed by path only
+	defaultMaxContentSize = 1 << 20
+)
+
+// FileWatchConfig configures a "file" trigger: the directory is scanned on
+// every interval and each created or modified file starts a run, with the file
+// as the trigger result
+type FileWatchConfig struct {
+	Path           string `json:"path"`                       // directory to watch
+	Pattern        string `json:"pattern,omitempty"`          // glob on the file name, e.g. "*.csv", or on the relative path when it contains a "/"
+	Recursive      bool   `json:"recursive,omitempty"`        // also watch subdirectories
+	Interval       string `json:"interval,omitempty"`         // e.g. "10s", defaults to 2s
+	MaxContentSize int64  `json:"max_content_size,omitempty"` // in bytes, defaults to 1MB
+}
+
+// fileState identifies a version of a file, a change of either field is a
+// modification
+type fileState struct {
+	size    int64
+	modTime int64 // unix nanoseconds
+}
+
+// execFile watches the configured directory and starts one run per created
+// or modified file. Files present at activation are not reported.
+func (t *Trigger) execFile(rt *ChainRuntime, db *gorm.DB) (stop func(), err error) {
+	cfg := t.File
+	if cfg == nil || cfg.Path == "" {
+		return nil, fmt.Errorf("file trigger requires a path")
+	}
+	info, err := os.Stat(cfg.Path)
+	if err != nil {
+		return nil, fmt.Errorf("failed to watch %s: %v", cfg.Path, err)
+	}
+	if !info.IsDir() {
+		return nil, fmt.Errorf("failed to watch %s: not a directory", cfg.Path)
+	}
+	if _, err := filepath.Match(cfg.Pattern, ""); err != nil {
+		return nil, fmt.Errorf("invalid file pattern %q: %v", cfg.Pattern, err)
+	}
+	interval, err := parseTimeout(cfg.Interval)
+	if err != nil {
+		return nil, err
+	}
+	if interval <= 0 {
+		interval = defaultFileScanInterval
+	}
+
+	known, err := cfg.scan()
+	if err != nil {
+		return nil, err
+	}
+
+	rt.SetEndpoint(fmt.Sprintf("file %s every %s", filepath.Join(cfg.Path, cfg.Pattern), interval))
+	fmt.Printf("Watching %s every %s for action chain %s...\n", cfg.Path, interval, rt.chain.ID)
+
+	watchCtx, cancel := context.WithCancel(rt.ctx)
+	go func() {
+		ticker := time.NewTicker(interval)
+		defer ticker.Stop()
+
+		// Changed files are reported once they stop changing for a whole
+		// interval, so files still being written are not picked up half-way
+		pending := make(map[string]fileState)
+		for {
+			select {
+			case <-watchCtx.Done():
+				return
+			case <-ticker.C:
+			}
+
+			files, err := cfg.scan()
+			if err != nil {
+				log.Printf("Scan of %s for chain %s failed: %v", cfg.Path, rt.chain.ID, err)
+				rt.RecordError(err)
+				continue
+			}
+			for path, state := range files {
+				previous, seen := known[path]
+				if seen && previous == state {
+					delete(pending, path)
+					continue
+				}
+				if last, ok := pending[path]; !ok || last != state {
+					pending[path] = state
+					continue
+				}
+
+				event := "modified"
+				if !seen {
+					event = "created"
+				}
+				if err := rt.Fire(db, cfg.describe(path, state, event)); errors.Is(err, ErrTooManyRuns) {
+					// Stays pending, the next scan tries again
+					rt.RecordError(fmt.Errorf("too many concurrent runs, %s deferred to the next scan", path))
+					continue
+				}
+				delete(pending, path)
+				known[path] = state
+			}
+			for path := range known {
+				if _, ok := files[path]; !ok {
+					delete(known, path)
+				}
+			}
+			for path := range pending {
+				if _, ok := files[path]; !ok {
+					delete(pending, path)
+				}
+			}
+		}
+	}()
+
+	return cancel, nil
+}
+
+// scan lists the regular files of the watched directory matching the pattern
+func (cfg *FileWatchConfig) scan() (map[string]fileState, error) {
+	files := make(map[string]fileState)
+	err := filepath.WalkDir(cfg.Path, func(path string, d fs.DirEntry, err error) error {
+		if err != nil {
+			if path == cfg.Path {
+				return err
+			}
+			// Files can disappear between listing and stat
+			return nil
+		}
+		if d.IsDir() {
+			if path != cfg.Path && !cfg.Recursive {
+				return filepath.SkipDir
+			}
+			return nil
+		}
+		if !d.Type().IsRegular() || !cfg.matches(path) {
+			return nil
+		}
+		info, err := d.Info()
+		if err != nil {
+			return nil
+		}
+		files[path] = fileState{size: info.Size(), modTime: info.ModTime().UnixNano()}
+		return nil
+	})
+	return files, err
+}
+
+func (cfg *FileWatchConfig) matches(path string) bool {
+	if cfg.Pattern == "" {
+		return true
+	}
+	name := filepath.Base(path)
+	if strings.Contains(cfg.Pattern, "/") {
+		name, _ = filepath.Rel(cfg.Path, path)
+		name = filepath.ToSlash(name)
+	}
+	ok, _ := filepath.Match(cfg.Pattern, name)
+	return ok
+}
+
+// describe returns the trigger result for a file. Text files up to the
+// maximum content size are read, other files are only referenced by path.
+func (cfg *FileWatchConfig) describe(path string, state fileState, event string) map[string]interface{} {
+	relPath, _ := filepath.Rel(cfg.Path, path)
+	absPath, err := filepath.Abs(path)
+	if err != nil {
+		absPath = path
+	}
+	file := map[string]interface{}{
+		"event":    event,
+		"path":     absPath,
+		"rel_path": filepath.ToSlash(relPath),
+		"name":     filepath.Base(path),
+		"size":     state.size,
+		"mod_time": time.Unix(0, state.modTime).Format(time.RFC3339Nano),
+	}
+
+	maxSize := cfg.MaxContentSize
+	if maxSize <= 0 {
+		maxSize = defaultMaxContentSize
+	}
+	if state.size > maxSize {
+		file["content_ref"] = absPath
+		return file
+	}
+	content, err := os.ReadFile(path)
+	if err != nil {
+		log.Printf("Error reading %s: %v", path, err)
+		file["content_ref"] = absPath
+		return file
+	}
+	file["content_type"] = http.DetectContentType(content)
+	if utf8.Valid(content) && !strings.ContainsRune(string(content), 0) {
+		file["content"] = string(content)
+	} else {
+		file["content_ref"] = absPath
+	}
+	return file
+}
@@ -0,0 +1,169 @@
+package models
+
+import (
+	"context"
+	"encoding/json"
+	"fmt"
+	"sync"
+)
+
+type ForEachActionData struct {
+	Items           string   `json:"items"`             // placeholder resolving to a JSON array, e.g. "[[orders]]"
+	Actions         []string `json:"actions"`           // action ID or sequence of action IDs run for each item
+	ItemName        string   `json:"item_name"`         // context key of the current item, defaults to "item"
+	IndexName       string   `json:"index_name"`        // context key of the current index, defaults to "index"
+	Concurrency     int      `json:"concurrency"`       // items processed at once, defaults to 1
+	ContinueOnError bool     `json:"continue_on_error"` // keep going when an item fails
+}
+
+func GetForEachActionData(a *Action) (*ForEachActionData, error) {
+	data := &ForEachActionData{}
+	if a.Metadata["items"] != nil {
+		data.Items = a.Metadata["items"].(string)
+	}
+	if a.Metadata["actions"] != nil {
+		actions, err := parseActionSequence(a.Metadata["actions"])
+		if err != nil {
+			return nil, fmt.Errorf("actions: %v", err)
+		}
+		data.Actions = actions
+	}
+	if a.Metadata["item_name"] != nil {
+		data.ItemName = a.Metadata["item_name"].(string)
+	}
+	if a.Metadata["index_name"] != nil {
+		data.IndexName = a.Metadata["index_name"].(string)
+	}
+	if a.Metadata["concurrency"] != nil {
+		data.Concurrency = int(a.Metadata["concurrency"].(float64))
+	}
+	if a.Metadata["continue_on_error"] != nil {
+		data.ContinueOnError = a.Metadata["continue_on_error"].(bool)
+	}
+	return data, nil
+}
+
+func ForEachActionDataToMetadata(data *ForEachActionData) map[string]interface{} {
+	return map[string]interface{}{
+		"items":             data.Items,
+		"actions":           data.Actions,
+		"item_name":         data.ItemName,
+		"index_name":        data.IndexName,
+		"concurrency":       data.Concurrency,
+		"continue_on_error": data.ContinueOnError,
+	}
+}
+
+// ExecForEach runs the actions once per item of the array, each time in a
+// child context holding the item and its index. The result of the last action
+// with a ResultID for each item is collected, in order, into an array stored
+// under the action's ResultID. Failed items are stored as {"error": "..."}.
+func (a *Action) ExecForEach(runCtx context.Context, ctx *ActionChainContext) error {
+	f, err := GetForEachActionData(a)
+	if err != nil {
+		return err
+	}
+	if ctx.db == nil {
+		return fmt.Errorf("foreach action requires a database")
+	}
+	if len(f.Actions) == 0 {
+		return fmt.Errorf("foreach action has no actions")
+	}
+	itemName, indexName := f.ItemName, f.IndexName
+	if itemName == "" {
+		itemName = "item"
+	}
+	if indexName == "" {
+		indexName = "index"
+	}
+	concurrency := f.Concurrency
+	if concurrency <= 0 {
+		concurrency = 1
+	}
+
+	itemsJSON, err := a.ProcessBody(ctx, f.Items)
+	if err != nil {
+		return err
+	}
+	var items []interface{}
+	if err := json.Unmarshal([]byte(itemsJSON), &items); err != nil {
+		return fmt.Errorf("items do not resolve to a JSON array: %v", err)
+	}
+	actions, err := loadActionSequence(ctx, f.Actions)
+	if err != nil {
+		return err
+	}
+
+	loopCtx, cancel := context.WithCancel(runCtx)
+	defer cancel()
+
+	results := make([]interface{}, len(items))
+	var mu sync.Mutex
+	var firstErr error
+	slots := make(chan struct{}, concurrency)
+	var wg sync.WaitGroup
+	for i, item := range items {
+		select {
+		case slots <- struct{}{}:
+		case <-loopCtx.Done():
+		}
+		if loopCtx.Err() != nil {
+			break
+		}
+
+		wg.Add(1)
+		go func(i int, item interface{}) {
+			defer wg.Done()
+			defer func() { <-slots }()
+
+			// Actions such as if_then change their FollowingActionID, every
+			// item needs its own copies
+			own := make([]*Action, len(actions))
+			for j, action := range actions {
+				copied := *action
+				own[j] = &copied
+			}
+
+			scope := ctx.child()
+			scope.Set(itemName, item)
+			scope.Set(indexName, i)
+			result, err := execSequence(loopCtx, scope, own)
+
+			mu.Lock()
+			defer mu.Unlock()
+			if err != nil {
+				results[i] = map[string]interface{}{"error": err.Error()}
+				if !f.ContinueOnError && firstErr == nil {
+					firstErr = fmt.Errorf("item %d: %v", i, err)
+					cancel()
+				}
+				return
+			}
+			results[i] = result
+		}(i, item)
+	}
+	wg.Wait()
+
+	if a.ResultID != "" {
+		ctx.Set(a.ResultID, results)
+	}
+	if err := runCtx.Err(); err != nil {
+		return fmt.Errorf("foreach stopped: %v", err)
+	}
+	return firstErr
+}
+
+// execSequence runs actions in order, with their timeouts and retry policies,
+// and returns the result of the last one with a ResultID
+func execSequence(runCtx context.Context, ctx *ActionChainContext, actions []*Action) (interface{}, error) {
+	var result interface{}
+	for _, action := range actions {
+		if err := action.execWithRetry(runCtx, ctx, nil); err != nil {
+			return nil, fmt.Errorf("action %s: %v", action.ID, err)
+		}
+		if action.ResultID != "" {
+			result = ctx.Get(action.ResultID)
+		}
+	}
+	return result, nil
+}
@@ -2,6 +2,7 @@ package models
 
 import (
 	"bytes"
+	"context"
 	"encoding/json"
 	"fmt"
 	"io"
@@ -60,9 +61,7 @@ func HTTPActionDataToMetadata(data *HTTPActionData) map[string]interface{} {
 	}
 }
 
-func (a *Action) ExecHTTP(ctx *ActionChainContext) error {
-	client := &http.Client{}
-
+func (a *Action) ExecHTTP(runCtx context.Context, ctx *ActionChainContext) error {
 	h, err := GetHTTPActionData(a)
 	if err != nil {
 		return err
@@ -74,6 +73,40 @@ func (a *Action) ExecHTTP(ctx *ActionChainContext) error {
 		return err
 	}
 
+	statusCode, respBody, err := h.send(runCtx, body)
+	if err != nil {
+		return err
+	}
+
+	if a.ResultID != "" {
+		// Parse the JSON response
+		var jsonData interface{}
+		err = json.Unmarshal(respBody, &jsonData)
+		if err != nil {
+			log.Printf("Error parsing JSON response: %v", err)
+			// Store the raw response if JSON parsing fails
+			ctx.Set(a.ResultID, string(respBody))
+		} else {
+			// Store the parsed JSON in ctx.Results
+			ctx.Set(a.ResultID, jsonData)
+		}
+
+		// fmt.Printf("Stored in ctx.Results[%s]: %+v\n", a.ResultID, ctx.Results[a.ResultID])
+	}
+
+	// Statuses declared retryable by the retry policy fail the attempt
+	if a.Retry.retriesStatus(statusCode) {
+		return &HTTPStatusError{StatusCode: statusCode, Body: string(respBody)}
+	}
+
+	return nil
+}
+
+// send performs the request with the already processed body, replacing secret
+// placeholders in headers, and returns the response status and body
+func (h *HTTPActionData) send(runCtx context.Context, body string) (int, []byte, error) {
+	client := &http.Client{}
+
 	var bodyReader io.Reader
 	if h.Headers["Content-Type"] == "application/json" {
 		// Parse the body as JSON and re-encode it to ensure it's valid
@@ -83,21 +116,21 @@ func (a *Action) ExecHTTP(ctx *ActionChainContext) error {
 			cleanBody := strings.Replace(body, "\n", "\\n", -1)
 			cleanBody = strings.Replace(cleanBody, "\r", "\\r", -1)
 			if err := json.Unmarshal([]byte(cleanBody), &jsonBody); err != nil {
-				return fmt.Errorf("invalid JSON body: %v", err)
+				return 0, nil, fmt.Errorf("invalid JSON body: %v", err)
 			}
 		}
 		encodedBody, err := json.Marshal(jsonBody)
 		if err != nil {
-			return fmt.Errorf("failed to encode JSON body: %v", err)
+			return 0, nil, fmt.Errorf("failed to encode JSON body: %v", err)
 		}
 		bodyReader = bytes.NewBuffer(encodedBody)
 	} else {
 		bodyReader = bytes.NewBufferString(body)
 	}
 
-	req, err := http.NewRequest(h.Method, h.URL, bodyReader)
+	req, err := http.NewRequestWithContext(runCtx, h.Method, h.URL, bodyReader)
 	if err != nil {
-		return err
+		return 0, nil, err
 	}
 
 	secretRe := regexp.MustCompile(`{{(.+?)}}`)
@@ -113,32 +146,15 @@ func (a *Action) ExecHTTP(ctx *ActionChainContext) error {
 
 	resp, err := client.Do(req)
 	if err != nil {
-		return err
+		return 0, nil, err
 	}
 	defer resp.Body.Close()
 
 	respBody, err := io.ReadAll(resp.Body)
 	if err != nil {
-		return err
+		return 0, nil, err
 	}
-
-	if a.ResultID != "" {
-		// Parse the JSON response
-		var jsonData interface{}
-		err = json.Unmarshal(respBody, &jsonData)
-		if err != nil {
-			log.Printf("Error parsing JSON response: %v", err)
-			// Store the raw response if JSON parsing fails
-			ctx.Results[a.ResultID] = string(respBody)
-		} else {
-			// Store the parsed JSON in ctx.Results
-			ctx.Results[a.ResultID] = jsonData
-		}
-
-		// fmt.Printf("Stored in ctx.Results[%s]: %+v\n", a.ResultID, ctx.Results[a.ResultID])
-	}
-
-	return nil
+	return resp.StatusCode, respBody, nil
 }
 
 func OpenAPIToHTTPActions(filename string) ([]Action, error) {
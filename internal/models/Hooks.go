@@ -0,0 +1,50 @@
+package models
+
+import (
+	"fmt"
+	"net/http"
+	"strings"
+	"sync"
+)
+
+// hookPrefix is where webhook triggers are mounted on the main server
+const hookPrefix = "/hooks/"
+
+type hookHandler func(w http.ResponseWriter, r *http.Request, path string)
+
+var (
+	hooks      = make(map[string]hookHandler)
+	hooksMutex sync.RWMutex
+)
+
+func registerHook(chainID string, handler hookHandler) error {
+	hooksMutex.Lock()
+	defer hooksMutex.Unlock()
+	if _, ok := hooks[chainID]; ok {
+		return fmt.Errorf("a webhook is already mounted for action chain %s", chainID)
+	}
+	hooks[chainID] = handler
+	return nil
+}
+
+func unregisterHook(chainID string) {
+	hooksMutex.Lock()
+	defer hooksMutex.Unlock()
+	delete(hooks, chainID)
+}
+
+// ServeHooks dispatches requests to /hooks/{chainID}/{path} to the webhook
+// trigger of the active chain
+func ServeHooks(w http.ResponseWriter, r *http.Request) {
+	chainID, path, _ := strings.Cut(strings.TrimPrefix(r.URL.Path, hookPrefix), "/")
+
+	hooksMutex.RLock()
+	handler, ok := hooks[chainID]
+	hooksMutex.RUnlock()
+	if !ok {
+		http.Error(w, fmt.Sprintf("No active webhook for action chain %s", chainID), http.StatusNotFound)
+		return
+	}
+
+	handler(w, r, "/"+path)
+}
@@ -320,7 +320,7 @@ func (c *LLMClient) handleNonStreamingResponse(body io.ReadCloser, responseChan
 	}
 }
 
-func (a *Action) ExecLLM(ctx *ActionChainContext) error {
+func (a *Action) ExecLLM(runCtx context.Context, ctx *ActionChainContext) error {
 	l, err := GetLLMActionData(a)
 	if err != nil {
 		return err
@@ -342,12 +342,12 @@ func (a *Action) ExecLLM(ctx *ActionChainContext) error {
 		// fmt.Printf("Message %d: %s\n", i, l.ChatCompletionRequest.Messages[i].Content)
 	}
 	// fmt.Printf("ChatCompletionRequest: %+v\n", l.ChatCompletionRequest)
-	respChan, errChan := l.Completion(context.Background(), l.ChatCompletionRequest)
+	respChan, errChan := l.Completion(runCtx, l.ChatCompletionRequest)
 
 	select {
 	case responseTxt := <-respChan:
 		// fmt.Printf("Response: %s\n", responseTxt)
-		ctx.Results[a.ResultID] = responseTxt
+		ctx.Set(a.ResultID, responseTxt)
 		return nil
 	case err := <-errChan:
 		log.Printf("Error in Completion: %v", err)
@@ -1,6 +1,7 @@
 package models
 
 import (
+	"context"
 	"fmt"
 )
 
@@ -75,15 +76,18 @@ func LoopActionDataToMetadata(data *LoopActionData) map[string]interface{} {
 	}
 }
 
-func (a *Action) ExecLoop(ctx *ActionChainContext) error {
+func (a *Action) ExecLoop(runCtx context.Context, ctx *ActionChainContext) error {
 	l, err := GetLoopActionData(a)
 	if err != nil {
 		return err
 	}
 	for {
+		if err := runCtx.Err(); err != nil {
+			return fmt.Errorf("loop stopped: %v", err)
+		}
 		// fmt.Printf("Action: %+v\n", l.Action)
 		// Execute the action
-		if err := l.Action.Exec(ctx); err != nil {
+		if err := l.Action.Exec(runCtx, ctx); err != nil {
 			return fmt.Errorf("error executing action: %v", err)
 		}
 		cond, err := a.ProcessBody(ctx, l.Condition)
@@ -0,0 +1,237 @@
+package models
+
+import (
+	"context"
+	"fmt"
+	"strings"
+	"sync"
+)
+
+const (
+	JoinAll          = "all"           // wait for every branch, fail if any failed
+	JoinFirstSuccess = "first_success" // complete with the first successful branch
+	JoinNOfM         = "n_of_m"        // complete once Required branches succeeded
+)
+
+type ParallelActionData struct {
+	Branches       [][]string `json:"branches"`        // action IDs of each branch, run in sequence within the branch
+	Join           string     `json:"join"`            // "all" (default), "first_success" or "n_of_m"
+	Required       int        `json:"required"`        // successful branches needed by "n_of_m"
+	MaxConcurrency int        `json:"max_concurrency"` // branches running at once, 0 means unlimited
+}
+
+func GetParallelActionData(a *Action) (*ParallelActionData, error) {
+	data := &ParallelActionData{}
+	if a.Metadata["branches"] != nil {
+		branches, ok := a.Metadata["branches"].([]interface{})
+		if !ok {
+			return nil, fmt.Errorf("branches is not a list")
+		}
+		for i, b := range branches {
+			branch, err := parseActionSequence(b)
+			if err != nil {
+				return nil, fmt.Errorf("branch %d: %v", i, err)
+			}
+			data.Branches = append(data.Branches, branch)
+		}
+	}
+	if a.Metadata["join"] != nil {
+		data.Join = a.Metadata["join"].(string)
+	}
+	if a.Metadata["required"] != nil {
+		data.Required = int(a.Metadata["required"].(float64))
+	}
+	if a.Metadata["max_concurrency"] != nil {
+		data.MaxConcurrency = int(a.Metadata["max_concurrency"].(float64))
+	}
+	return data, nil
+}
+
+// parseActionSequence reads metadata holding an action ID or a list of action
+// IDs run in sequence
+func parseActionSequence(value interface{}) ([]string, error) {
+	switch v := value.(type) {
+	case string:
+		return []string{v}, nil
+	case []interface{}:
+		ids := make([]string, len(v))
+		for i, id := range v {
+			strValue, ok := id.(string)
+			if !ok {
+				return nil, fmt.Errorf("action ID at index %d is not a string", i)
+			}
+			ids[i] = strValue
+		}
+		return ids, nil
+	default:
+		return nil, fmt.Errorf("expected an action ID or a list of action IDs")
+	}
+}
+
+// loadActionSequence retrieves the actions of a sequence in order
+func loadActionSequence(ctx *ActionChainContext, ids []string) ([]*Action, error) {
+	actions := make([]*Action, 0, len(ids))
+	for _, id := range ids {
+		action, err := getActionByID(ctx.db, id)
+		if err != nil {
+			return nil, fmt.Errorf("failed to retrieve action %s: %v", id, err)
+		}
+		actions = append(actions, &action)
+	}
+	return actions, nil
+}
+
+func ParallelActionDataToMetadata(data *ParallelActionData) map[string]interface{} {
+	return map[string]interface{}{
+		"branches":        data.Branches,
+		"join":            data.Join,
+		"required":        data.Required,
+		"max_concurrency": data.MaxConcurrency,
+	}
+}
+
+// branchOutcome is the result of one branch of a parallel action
+type branchOutcome struct {
+	index   int
+	status  string
+	err     error
+	results map[string]interface{} // results of the branch's actions by result ID
+	scope   *ActionChainContext    // what the branch stored, nil if it never started
+}
+
+// ExecParallel runs the branches concurrently, each in a child context, and
+// joins them according to the join policy. What the branches stored is then
+// merged into the context in branch order, and the outcome of every branch is
+// stored under the action's ResultID.
+func (a *Action) ExecParallel(runCtx context.Context, ctx *ActionChainContext) error {
+	p, err := GetParallelActionData(a)
+	if err != nil {
+		return err
+	}
+	if ctx.db == nil {
+		return fmt.Errorf("parallel action requires a database")
+	}
+	total := len(p.Branches)
+	if total == 0 {
+		return fmt.Errorf("parallel action has no branches")
+	}
+	required := total
+	switch p.Join {
+	case "", JoinAll:
+	case JoinFirstSuccess:
+		required = 1
+	case JoinNOfM:
+		if p.Required < 1 || p.Required > total {
+			return fmt.Errorf("n_of_m join requires between 1 and %d successful branches, got %d", total, p.Required)
+		}
+		required = p.Required
+	default:
+		return fmt.Errorf("unknown join policy: %s", p.Join)
+	}
+
+	// Load every action first so a missing one fails before anything runs
+	branches := make([][]*Action, total)
+	for i, ids := range p.Branches {
+		if branches[i], err = loadActionSequence(ctx, ids); err != nil {
+			return fmt.Errorf("branch %d: %v", i, err)
+		}
+	}
+
+	joinCtx, cancel := context.WithCancel(runCtx)
+	defer cancel()
+
+	var slots chan struct{}
+	if p.MaxConcurrency > 0 {
+		slots = make(chan struct{}, p.MaxConcurrency)
+	}
+	outcomes := make(chan branchOutcome, total)
+	var wg sync.WaitGroup
+	for i, actions := range branches {
+		wg.Add(1)
+		go func(i int, actions []*Action) {
+			defer wg.Done()
+			if slots != nil {
+				select {
+				case slots <- struct{}{}:
+					defer func() { <-slots }()
+				case <-joinCtx.Done():
+					outcomes <- branchOutcome{index: i, status: RunStatusCancelled, err: joinCtx.Err()}
+					return
+				}
+			}
+			outcomes <- execBranch(joinCtx, ctx, i, actions)
+		}(i, actions)
+	}
+
+	// Stop the remaining branches as soon as the join is decided
+	scopes := make([]*ActionChainContext, total)
+	results := make([]interface{}, total)
+	succeeded, failed := 0, 0
+	var failures []string
+	for received := 0; received < total; received++ {
+		o := <-outcomes
+		branch := map[string]interface{}{
+			"status":  o.status,
+			"results": o.results,
+		}
+		if o.err != nil {
+			branch["error"] = o.err.Error()
+		}
+		results[o.index] = branch
+		scopes[o.index] = o.scope
+
+		switch {
+		case o.err == nil:
+			succeeded++
+		case o.status != RunStatusCancelled || runCtx.Err() != nil:
+			failed++
+			failures = append(failures, fmt.Sprintf("branch %d: %v", o.index, o.err))
+		}
+		if succeeded >= required || failed > total-required {
+			cancel()
+		}
+	}
+	wg.Wait()
+
+	for _, scope := range scopes {
+		if scope == nil {
+			continue
+		}
+		for key, value := range scope.Results {
+			ctx.Set(key, value)
+		}
+	}
+	if a.ResultID != "" {
+		ctx.Set(a.ResultID, map[string]interface{}{
+			"branches":  results,
+			"succeeded": succeeded,
+			"failed":    failed,
+		})
+	}
+	if err := runCtx.Err(); err != nil {
+		return fmt.Errorf("parallel action stopped: %v", err)
+	}
+	if succeeded < required {
+		return fmt.Errorf("%d of %d branches succeeded, %d required: %s", succeeded, total, required, strings.Join(failures, "; "))
+	}
+	return nil
+}
+
+// execBranch runs the actions of a branch in sequence in a child context, with
+// their timeouts and retry policies
+func execBranch(runCtx context.Context, ctx *ActionChainContext, index int, actions []*Action) branchOutcome {
+	scope := ctx.child()
+	outcome := branchOutcome{index: index, results: make(map[string]interface{}), scope: scope}
+	for _, action := range actions {
+		if err := action.execWithRetry(runCtx, scope, nil); err != nil {
+			outcome.status = runStatus(runCtx, err)
+			outcome.err = fmt.Errorf("action %s: %v", action.ID, err)
+			return outcome
+		}
+		if action.ResultID != "" {
+			outcome.results[action.ResultID] = scope.Get(action.ResultID)
+		}
+	}
+	outcome.status = RunStatusSucceeded
+	return outcome
+}
@@ -0,0 +1,217 @@
+package models
+
+import (
+	"context"
+	"encoding/json"
+	"fmt"
+	"log"
+	"strconv"
+	"strings"
+	"time"
+
+	"gorm.io/gorm"
+	"gorm.io/gorm/clause"
+)
+
+// defaultPollInterval is used when a poll trigger does not set an interval
+const defaultPollInterval = time.Minute
+
+// PollConfig configures a "poll" trigger: the request is sent on every
+// interval and each item of the response not seen before starts a run, with
+// the item as the trigger result
+type PollConfig struct {
+	HTTPActionData
+	Interval    string `json:"interval,omitempty"`     // e.g. "5m", defaults to 1m
+	ItemsPath   string `json:"items_path,omitempty"`   // dot path to the list in the response, e.g. "data.results"
+	KeyPath     string `json:"key_path,omitempty"`     // dot path to the de-duplication key in an item, e.g. "id"
+	SkipInitial bool   `json:"skip_initial,omitempty"` // mark the items of the first poll as seen without running
+}
+
+// pollFirstPollKey is the key of the PollSeenItem stored once the first poll
+// of a skip_initial trigger is done, even when it returned no items
+const pollFirstPollKey = ""
+
+// PollSeenItem remembers the items a poll trigger already started a run for
+type PollSeenItem struct {
+	ChainID string    `json:"chain_id" gorm:"primaryKey;type:varchar(100)"`
+	ItemKey string    `json:"item_key" gorm:"primaryKey;type:varchar(255)"`
+	SeenAt  time.Time `json:"seen_at"`
+}
+
+// execPoll polls the configured endpoint and starts one run per new item
+func (t *Trigger) execPoll(rt *ChainRuntime, db *gorm.DB) (stop func(), err error) {
+	cfg := t.Poll
+	if cfg == nil {
+		return nil, fmt.Errorf("poll trigger requires a poll configuration")
+	}
+	if cfg.URL == "" {
+		return nil, fmt.Errorf("poll trigger requires a url")
+	}
+	if cfg.Method == "" {
+		cfg.Method = "GET"
+	}
+	interval, err := parseTimeout(cfg.Interval)
+	if err != nil {
+		return nil, err
+	}
+	if interval <= 0 {
+		interval = defaultPollInterval
+	}
+
+	rt.SetEndpoint(fmt.Sprintf("poll %s %s every %s", cfg.Method, cfg.URL, interval))
+	fmt.Printf("Polling %s every %s for action chain %s...\n", cfg.URL, interval, rt.chain.ID)
+
+	pollCtx, cancel := context.WithCancel(rt.ctx)
+	go func() {
+		ticker := time.NewTicker(interval)
+		defer ticker.Stop()
+		for {
+			if err := t.poll(pollCtx, rt, db); err != nil && pollCtx.Err() == nil {
+				log.Printf("Poll of chain %s failed: %v", rt.chain.ID, err)
+				rt.RecordError(err)
+			}
+			select {
+			case <-pollCtx.Done():
+				return
+			case <-ticker.C:
+			}
+		}
+	}()
+
+	return cancel, nil
+}
+
+func (t *Trigger) poll(pollCtx context.Context, rt *ChainRuntime, db *gorm.DB) error {
+	cfg := t.Poll
+
+	// Only secrets can be used in the request of a poll trigger
+	body, err := processTemplate(&ActionChainContext{}, nil, cfg.Body)
+	if err != nil {
+		return err
+	}
+	statusCode, respBody, err := cfg.send(pollCtx, body)
+	if err != nil {
+		return err
+	}
+	if statusCode < 200 || statusCode >= 300 {
+		return &HTTPStatusError{StatusCode: statusCode, Body: string(respBody)}
+	}
+
+	var response interface{}
+	if err := json.Unmarshal(respBody, &response); err != nil {
+		return fmt.Errorf("invalid JSON response: %v", err)
+	}
+	value, ok := lookupPath(response, cfg.ItemsPath)
+	if !ok {
+		return fmt.Errorf("items path %q not found in response", cfg.ItemsPath)
+	}
+	items, ok := value.([]interface{})
+	if !ok {
+		return fmt.Errorf("items path %q is not a list", cfg.ItemsPath)
+	}
+
+	// The first poll is the one before any row, items or marker, was stored
+	firstPoll := false
+	if cfg.SkipInitial {
+		var count int64
+		if err := db.Model(&PollSeenItem{}).Where("chain_id = ?", rt.chain.ID).Count(&count).Error; err != nil {
+			return err
+		}
+		firstPoll = count == 0
+	}
+
+	for _, item := range items {
+		key, err := itemKey(item, cfg.KeyPath)
+		if err != nil {
+			log.Printf("Poll of chain %s: skipping item: %v", rt.chain.ID, err)
+			continue
+		}
+
+		var release func()
+		if !firstPoll {
+			var ok bool
+			if release, ok = rt.acquire(); !ok {
+				// Leave the remaining items unseen, the next poll picks them up
+				return fmt.Errorf("too many concurrent runs, new items deferred to the next poll")
+			}
+		}
+
+		// Marking the item first guarantees it starts at most one run
+		seen := PollSeenItem{ChainID: rt.chain.ID, ItemKey: key, SeenAt: time.Now()}
+		result := db.Clauses(clause.OnConflict{DoNothing: true}).Create(&seen)
+		if result.Error != nil || result.RowsAffected == 0 || firstPoll {
+			if release != nil {
+				release()
+			}
+			if result.Error != nil {
+				return result.Error
+			}
+			continue
+		}
+
+		ctx := NewRunContext(rt.chain.Context)
+		ctx.Set(t.ResultID, item)
+		if !rt.accepts(db, ctx, item) {
+			release()
+			continue
+		}
+		go func(item interface{}) {
+			defer release()
+			rt.startRun(db, ctx, t.FollowingActionID, item)
+		}(item)
+	}
+	if firstPoll {
+		marker := PollSeenItem{ChainID: rt.chain.ID, ItemKey: pollFirstPollKey, SeenAt: time.Now()}
+		if err := db.Clauses(clause.OnConflict{DoNothing: true}).Create(&marker).Error; err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+// itemKey returns the de-duplication key of a polled item, the whole item
+// when no key path is configured. Empty keys are rejected, they are reserved
+// for the first poll marker.
+func itemKey(item interface{}, keyPath string) (string, error) {
+	value, ok := lookupPath(item, keyPath)
+	if !ok || value == nil {
+		return "", fmt.Errorf("key path %q not found in item", keyPath)
+	}
+	if str, ok := value.(string); ok {
+		if str == pollFirstPollKey {
+			return "", fmt.Errorf("key path %q is empty in item", keyPath)
+		}
+		return str, nil
+	}
+	data, err := json.Marshal(value)
+	if err != nil {
+		return "", err
+	}
+	return string(data), nil
+}
+
+// lookupPath follows a dot separated path such as "data.items.0.id" through
+// JSON objects and arrays, an empty path returns the value itself
+func lookupPath(value interface{}, path string) (interface{}, bool) {
+	if path == "" {
+		return value, true
+	}
+	for _, name := range strings.Split(path, ".") {
+		switch v := value.(type) {
+		case map[string]interface{}:
+			var ok bool
+			if value, ok = v[name]; !ok {
+				return nil, false
+			}
+		case []interface{}:
+			index, err := strconv.Atoi(name)
+			if err != nil || index < 0 || index >= len(v) {
+				return nil, false
+			}
+			value = v[index]
+		default:
+			return nil, false
+		}
+	}
+	return value, true
+}
@@ -0,0 +1,280 @@
+package models
+
+import (
+	"context"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"log"
+	"time"
+
+	"longboy/internal/utils"
+
+	"gorm.io/gorm"
+)
+
+const (
+	defaultVisibilityTimeout = 30 * time.Second
+	minVisibilityTimeout     = time.Second
+	defaultQueuePollInterval = time.Second
+	defaultQueueMaxAttempts  = 5
+	defaultQueueConcurrency  = 1
+)
+
+// QueueMessage is a message of the internal queue. A message is delivered
+// once its VisibleAt is reached, and deleted when a run processed it.
+type QueueMessage struct {
+	ID         string          `json:"id" gorm:"primaryKey"`
+	Queue      string          `json:"queue" gorm:"type:varchar(100);index:idx_queue_visible"`
+	Body       json.RawMessage `json:"body" gorm:"type:text"`
+	Attempts   int             `json:"attempts"`
+	LastError  string          `json:"last_error,omitempty" gorm:"type:text"`
+	VisibleAt  time.Time       `json:"visible_at" gorm:"index:idx_queue_visible"`
+	EnqueuedAt time.Time       `json:"enqueued_at"`
+}
+
+// QueueConfig configures a "queue" trigger consuming a queue of the internal
+// job queue, filled by "enqueue" actions
+type QueueConfig struct {
+	Queue             string `json:"queue"`                        // name of the queue to consume
+	VisibilityTimeout string `json:"visibility_timeout,omitempty"` // how long a delivered message stays hidden before being redelivered, defaults to 30s
+	MaxAttempts       int    `json:"max_attempts,omitempty"`       // deliveries before a message goes to the dead-letter queue, defaults to 5
+	DeadLetterQueue   string `json:"dead_letter_queue,omitempty"`  // defaults to "<queue>.dead"
+	RetryDelay        string `json:"retry_delay,omitempty"`        // wait before redelivering a failed message, defaults to 0
+	PollInterval      string `json:"poll_interval,omitempty"`      // defaults to 1s
+	Concurrency       int    `json:"concurrency,omitempty"`        // messages processed at once, defaults to 1
+}
+
+// QueueStats counts the messages of a queue
+type QueueStats struct {
+	Queue    string `json:"queue"`
+	Ready    int64  `json:"ready"`
+	InFlight int64  `json:"in_flight"`
+}
+
+// Enqueue pushes a message on a queue, delivered after delay
+func Enqueue(db *gorm.DB, queue string, body interface{}, delay time.Duration) (*QueueMessage, error) {
+	if queue == "" {
+		return nil, fmt.Errorf("queue name is required")
+	}
+	now := time.Now()
+	msg := &QueueMessage{
+		ID:         utils.NewID(),
+		Queue:      queue,
+		Body:       toRawJSON(body),
+		VisibleAt:  now.Add(delay),
+		EnqueuedAt: now,
+	}
+	if err := db.Create(msg).Error; err != nil {
+		return nil, fmt.Errorf("failed to enqueue message: %v", err)
+	}
+	return msg, nil
+}
+
+// claimMessage hides the next visible message of the queue for the visibility
+// timeout and counts the delivery. It returns nil when the queue is empty.
+func claimMessage(db *gorm.DB, queue string, visibility time.Duration) (*QueueMessage, error) {
+	var msg QueueMessage
+	err := db.Transaction(func(tx *gorm.DB) error {
+		now := time.Now()
+		err := tx.Where("queue = ? AND visible_at <= ?", queue, now).Order("visible_at, enqueued_at").First(&msg).Error
+		if err != nil {
+			return err
+		}
+		msg.Attempts++
+		msg.VisibleAt = now.Add(visibility)
+		return tx.Model(&QueueMessage{}).Where("id = ?", msg.ID).
+			Updates(map[string]interface{}{"attempts": msg.Attempts, "visible_at": msg.VisibleAt}).Error
+	})
+	if errors.Is(err, gorm.ErrRecordNotFound) {
+		return nil, nil
+	}
+	if err != nil {
+		return nil, err
+	}
+	return &msg, nil
+}
+
+// execQueue consumes the configured queue, one run per message
+func (t *Trigger) execQueue(rt *ChainRuntime, db *gorm.DB) (stop func(), err error) {
+	cfg := t.Queue
+	if cfg == nil || cfg.Queue == "" {
+		return nil, fmt.Errorf("queue trigger requires a queue name")
+	}
+	visibility, err := parseTimeout(cfg.VisibilityTimeout)
+	if err != nil {
+		return nil, err
+	}
+	if visibility <= 0 {
+		visibility = defaultVisibilityTimeout
+	}
+	if visibility < minVisibilityTimeout {
+		return nil, fmt.Errorf("visibility timeout must be at least %s", minVisibilityTimeout)
+	}
+	retryDelay, err := parseTimeout(cfg.RetryDelay)
+	if err != nil {
+		return nil, err
+	}
+	interval, err := parseTimeout(cfg.PollInterval)
+	if err != nil {
+		return nil, err
+	}
+	if interval <= 0 {
+		interval = defaultQueuePollInterval
+	}
+	maxAttempts := cfg.MaxAttempts
+	if maxAttempts <= 0 {
+		maxAttempts = defaultQueueMaxAttempts
+	}
+	deadLetterQueue := cfg.DeadLetterQueue
+	if deadLetterQueue == "" {
+		deadLetterQueue = cfg.Queue + ".dead"
+	}
+	if deadLetterQueue == cfg.Queue {
+		return nil, fmt.Errorf("dead-letter queue must differ from the consumed queue")
+	}
+	concurrency := cfg.Concurrency
+	if concurrency <= 0 {
+		concurrency = defaultQueueConcurrency
+	}
+
+	consumer := &queueConsumer{
+		trigger:         t,
+		rt:              rt,
+		db:              db,
+		visibility:      visibility,
+		retryDelay:      retryDelay,
+		maxAttempts:     maxAttempts,
+		deadLetterQueue: deadLetterQueue,
+		slots:           make(chan struct{}, concurrency),
+	}
+
+	rt.SetEndpoint(fmt.Sprintf("queue %s (dead letters to %s)", cfg.Queue, deadLetterQueue))
+	fmt.Printf("Consuming queue %s for action chain %s...\n", cfg.Queue, rt.chain.ID)
+
+	consumeCtx, cancel := context.WithCancel(rt.ctx)
+	go func() {
+		ticker := time.NewTicker(interval)
+		defer ticker.Stop()
+		for {
+			if err := consumer.drain(consumeCtx); err != nil && consumeCtx.Err() == nil {
+				log.Printf("Consuming queue %s for chain %s failed: %v", cfg.Queue, rt.chain.ID, err)
+				rt.RecordError(err)
+			}
+			select {
+			case <-consumeCtx.Done():
+				return
+			case <-ticker.C:
+			}
+		}
+	}()
+
+	return cancel, nil
+}
+
+type queueConsumer struct {
+	trigger         *Trigger
+	rt              *ChainRuntime
+	db              *gorm.DB
+	visibility      time.Duration
+	retryDelay      time.Duration
+	maxAttempts     int
+	deadLetterQueue string
+	slots           chan struct{} // bounds the messages processed at once
+}
+
+// drain starts runs for the visible messages, as many at once as the consumer
+// concurrency allows, until the queue is empty or the chain reaches its
+// concurrency limit
+func (c *queueConsumer) drain(consumeCtx context.Context) error {
+	for {
+		select {
+		case c.slots <- struct{}{}:
+		case <-consumeCtx.Done():
+			return nil
+		}
+		release, ok := c.rt.acquire()
+		if !ok {
+			<-c.slots
+			return nil
+		}
+		msg, err := claimMessage(c.db, c.trigger.Queue.Queue, c.visibility)
+		if err != nil || msg == nil {
+			release()
+			<-c.slots
+			return err
+		}
+		go func() {
+			defer func() { <-c.slots }()
+			defer release()
+			c.process(consumeCtx, msg)
+		}()
+	}
+}
+
+// process runs the chain for a message, then acknowledges it on success or
+// schedules its redelivery, moving it to the dead-letter queue after the
+// last attempt
+func (c *queueConsumer) process(consumeCtx context.Context, msg *QueueMessage) {
+	var body interface{}
+	json.Unmarshal(msg.Body, &body)
+	payload := map[string]interface{}{
+		"id":          msg.ID,
+		"queue":       msg.Queue,
+		"body":        body,
+		"attempt":     msg.Attempts,
+		"enqueued_at": msg.EnqueuedAt.Format(time.RFC3339Nano),
+	}
+	ctx := NewRunContext(c.rt.chain.Context)
+	ctx.Set(c.trigger.ResultID, payload)
+
+	var err error
+	if c.rt.accepts(c.db, ctx, payload) {
+		// Keep the message hidden while the run lasts longer than the
+		// visibility timeout
+		heartbeatCtx, stopHeartbeat := context.WithCancel(consumeCtx)
+		go c.heartbeat(heartbeatCtx, msg.ID)
+		_, err = c.rt.startRun(c.db, ctx, c.trigger.FollowingActionID, payload)
+		stopHeartbeat()
+	}
+
+	if err == nil {
+		if err := c.db.Delete(&QueueMessage{}, "id = ?", msg.ID).Error; err != nil {
+			log.Printf("Failed to acknowledge message %s: %v", msg.ID, err)
+		}
+		return
+	}
+	if consumeCtx.Err() != nil {
+		// Deactivated or shutting down, the message becomes visible again
+		// after the visibility timeout without counting as a failure
+		c.db.Model(&QueueMessage{}).Where("id = ?", msg.ID).Update("attempts", gorm.Expr("attempts - 1"))
+		return
+	}
+
+	updates := map[string]interface{}{
+		"last_error": err.Error(),
+		"visible_at": time.Now().Add(c.retryDelay),
+	}
+	if msg.Attempts >= c.maxAttempts {
+		log.Printf("Message %s of queue %s failed %d times, moving it to %s", msg.ID, msg.Queue, msg.Attempts, c.deadLetterQueue)
+		updates["queue"] = c.deadLetterQueue
+		updates["attempts"] = 0
+		updates["visible_at"] = time.Now()
+	}
+	if err := c.db.Model(&QueueMessage{}).Where("id = ?", msg.ID).Updates(updates).Error; err != nil {
+		log.Printf("Failed to release message %s: %v", msg.ID, err)
+	}
+}
+
+func (c *queueConsumer) heartbeat(ctx context.Context, id string) {
+	ticker := time.NewTicker(c.visibility / 2)
+	defer ticker.Stop()
+	for {
+		select {
+		case <-ctx.Done():
+			return
+		case <-ticker.C:
+			c.db.Model(&QueueMessage{}).Where("id = ?", id).Update("visible_at", time.Now().Add(c.visibility))
+		}
+	}
+}
@@ -0,0 +1,107 @@
+package models
+
+import (
+	"context"
+	"fmt"
+	"math"
+	"math/rand"
+	"strings"
+	"time"
+)
+
+// defaultMaxRetryInterval bounds the delay between retries when the policy
+// sets no max_interval
+const defaultMaxRetryInterval = 24 * time.Hour
+
+// RetryPolicy describes how a failing action is retried before the run gives up
+type RetryPolicy struct {
+	MaxAttempts     int      `json:"max_attempts"`               // total attempts, including the first one
+	InitialInterval string   `json:"initial_interval,omitempty"` // delay before the first retry, defaults to "1s"
+	MaxInterval     string   `json:"max_interval,omitempty"`     // upper bound of the delay, defaults to "24h"
+	Multiplier      float64  `json:"multiplier,omitempty"`       // growth of the delay between retries, defaults to 2
+	Jitter          float64  `json:"jitter,omitempty"`           // randomization factor between 0 and 1
+	RetryOn         []string `json:"retry_on,omitempty"`         // substrings of retryable error messages
+	RetryOnStatus   []int    `json:"retry_on_status,omitempty"`  // retryable HTTP status codes, for http actions
+}
+
+// HTTPStatusError is returned by http actions when the response status is one
+// the retry policy of the action declares as retryable
+type HTTPStatusError struct {
+	StatusCode int
+	Body       string
+}
+
+func (e *HTTPStatusError) Error() string {
+	return fmt.Sprintf("request failed with status code %d: %s", e.StatusCode, e.Body)
+}
+
+// retriesStatus reports whether the policy treats the HTTP status as a failure to retry
+func (p *RetryPolicy) retriesStatus(statusCode int) bool {
+	if p == nil {
+		return false
+	}
+	for _, code := range p.RetryOnStatus {
+		if code == statusCode {
+			return true
+		}
+	}
+	return false
+}
+
+// shouldRetry reports whether another attempt should follow the failed one.
+// Without any retry_on or retry_on_status filter, every error is retried.
+func (p *RetryPolicy) shouldRetry(attempt int, err error) bool {
+	if p == nil || attempt >= p.MaxAttempts {
+		return false
+	}
+	if len(p.RetryOn) == 0 && len(p.RetryOnStatus) == 0 {
+		return true
+	}
+	if statusErr, ok := err.(*HTTPStatusError); ok && p.retriesStatus(statusErr.StatusCode) {
+		return true
+	}
+	for _, substr := range p.RetryOn {
+		if strings.Contains(err.Error(), substr) {
+			return true
+		}
+	}
+	return false
+}
+
+// backoff returns the delay to wait after the given failed attempt
+func (p *RetryPolicy) backoff(attempt int) time.Duration {
+	initial, err := parseTimeout(p.InitialInterval)
+	if err != nil || initial <= 0 {
+		initial = time.Second
+	}
+	multiplier := p.Multiplier
+	if multiplier <= 0 {
+		multiplier = 2
+	}
+
+	maxInterval, err := parseTimeout(p.MaxInterval)
+	if err != nil || maxInterval <= 0 {
+		maxInterval = defaultMaxRetryInterval
+	}
+
+	// Bound the delay before converting it, large attempts overflow a Duration
+	delay := float64(initial) * math.Pow(multiplier, float64(attempt-1))
+	delay = math.Min(delay, float64(maxInterval))
+	if p.Jitter > 0 {
+		jitter := math.Min(p.Jitter, 1)
+		delay += delay * jitter * (2*rand.Float64() - 1)
+	}
+	return time.Duration(delay)
+}
+
+// sleepContext waits for d, returning early if ctx is done
+func sleepContext(ctx context.Context, d time.Duration) error {
+	timer := time.NewTimer(d)
+	defer timer.Stop()
+	select {
+	case <-timer.C:
+		return nil
+	case <-ctx.Done():
+		return ctx.Err()
+	}
+}
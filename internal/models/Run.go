@@ -0,0 +1,370 @@
+package models
+
+import (
+	"context"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"log"
+	"runtime/debug"
+	"sync"
+	"time"
+
+	"longboy/internal/utils"
+
+	"gorm.io/gorm"
+)
+
+const (
+	RunStatusRunning   = "running"
+	RunStatusSucceeded = "succeeded"
+	RunStatusFailed    = "failed"
+	RunStatusCancelled = "cancelled"
+	RunStatusRecovered = "recovered" // a step failed but the run completed through an error handler
+	RunStatusFiltered  = "filtered"  // the trigger filter discarded the payload, no action ran
+	RunStatusWaiting   = "waiting"   // a step suspended the run until its wait ends
+)
+
+// ErrorResultID is the context key under which a failure is exposed to error handlers
+const ErrorResultID = "error"
+
+// runsInFlight tracks the runs currently executing so shutdown can wait for
+// them. Once runsClosed is set by WaitForRuns, no run is added anymore.
+var (
+	runsMutex    sync.Mutex
+	runsClosed   bool
+	runsInFlight sync.WaitGroup
+)
+
+// ErrShuttingDown is returned when a run would start after shutdown began
+var ErrShuttingDown = errors.New("server is shutting down")
+
+// beginRun counts a new run in flight, unless shutdown began. The run must
+// call endRun once it recorded its outcome.
+func beginRun() bool {
+	runsMutex.Lock()
+	defer runsMutex.Unlock()
+	if runsClosed {
+		return false
+	}
+	runsInFlight.Add(1)
+	return true
+}
+
+func endRun() {
+	runsInFlight.Done()
+}
+
+// Run is the persisted history of one execution of an action chain
+type Run struct {
+	ID        string          `json:"id" gorm:"primaryKey"`
+	ChainID   string          `json:"chain_id" gorm:"type:varchar(100);index"`
+	Status    string          `json:"status" gorm:"type:varchar(20)"`
+	Payload   json.RawMessage `json:"payload,omitempty" gorm:"type:text"`
+	Error     string          `json:"error,omitempty" gorm:"type:text"`
+	StartedAt time.Time       `json:"started_at"`
+	EndedAt   *time.Time      `json:"ended_at,omitempty"`
+	Hops      int             `json:"hops,omitempty"` // runs in a row started by chain triggers that led to this one
+	Steps     []RunStep       `json:"steps,omitempty" gorm:"foreignKey:RunID"`
+
+	errorActionID string // chain-level error handler
+}
+
+// RunStep records the execution of a single action inside a run
+type RunStep struct {
+	ID         uint            `json:"id" gorm:"primaryKey"`
+	RunID      string          `json:"run_id" gorm:"type:varchar(100);index"`
+	ActionID   string          `json:"action_id" gorm:"type:varchar(100)"`
+	ActionType string          `json:"action_type" gorm:"type:varchar(50)"`
+	Status     string          `json:"status" gorm:"type:varchar(20)"`
+	Error      string          `json:"error,omitempty" gorm:"type:text"`
+	Result     json.RawMessage `json:"result,omitempty" gorm:"type:text"`
+	Attempts   []StepAttempt   `json:"attempts,omitempty" gorm:"serializer:json"`
+	StartedAt  time.Time       `json:"started_at"`
+	EndedAt    *time.Time      `json:"ended_at,omitempty"`
+}
+
+// StepAttempt records one try of a step, steps with a retry policy may have several
+type StepAttempt struct {
+	Attempt   int       `json:"attempt"`
+	StartedAt time.Time `json:"started_at"`
+	EndedAt   time.Time `json:"ended_at"`
+	Error     string    `json:"error,omitempty"`
+}
+
+// StartRun records a new run of the chain and executes its actions, starting
+// from firstActionID and following FollowingActionID until the end of the chain
+// or the first failure that no error handler picks up. The run is returned even
+// when it failed.
+func StartRun(runCtx context.Context, db *gorm.DB, chain *ActionChain, ctx *ActionChainContext, firstActionID string, payload interface{}) (*Run, error) {
+	run := &Run{
+		ID:        utils.NewID(),
+		ChainID:   chain.ID,
+		Status:    RunStatusRunning,
+		Payload:   toRawJSON(payload),
+		StartedAt: time.Now(),
+		Hops:      ctx.hops,
+
+		errorActionID: chain.ErrorActionID,
+	}
+	if !beginRun() {
+		return nil, ErrShuttingDown
+	}
+	defer endRun()
+	if err := db.Create(run).Error; err != nil {
+		return nil, fmt.Errorf("failed to create run: %v", err)
+	}
+	ctx.db = db
+
+	err := run.execWithTimeout(runCtx, db, chain, ctx, firstActionID)
+	return run, run.finish(runCtx, db, ctx, err)
+}
+
+// execWithTimeout executes the actions from firstActionID, bounded by the
+// timeout of the chain
+func (r *Run) execWithTimeout(runCtx context.Context, db *gorm.DB, chain *ActionChain, ctx *ActionChainContext, firstActionID string) (err error) {
+	defer recoverPanic(&err, "run "+r.ID)
+	timeout, err := parseTimeout(chain.Timeout)
+	if err != nil {
+		return err
+	}
+	if timeout > 0 {
+		var cancel context.CancelFunc
+		runCtx, cancel = context.WithTimeout(runCtx, timeout)
+		defer cancel()
+	}
+	return r.execActions(runCtx, db, ctx, firstActionID)
+}
+
+// finish records the outcome of the run, or that it is waiting when a step
+// suspended it, in which case no error is returned
+func (r *Run) finish(runCtx context.Context, db *gorm.DB, ctx *ActionChainContext, err error) error {
+	if errors.Is(err, errRunSuspended) {
+		r.Status = RunStatusWaiting
+		if saveErr := db.Omit("Steps").Save(r).Error; saveErr != nil {
+			log.Printf("failed to save run %s: %v", r.ID, saveErr)
+		}
+		return nil
+	}
+
+	now := time.Now()
+	r.EndedAt = &now
+	r.Status = runStatus(runCtx, err)
+	if err != nil {
+		r.Error = err.Error()
+	} else if r.Error != "" {
+		r.Status = RunStatusRecovered
+	}
+	if saveErr := db.Omit("Steps").Save(r).Error; saveErr != nil {
+		log.Printf("failed to save run %s: %v", r.ID, saveErr)
+	}
+	publishRunCompleted(r, ctx)
+	return err
+}
+
+func (r *Run) execActions(runCtx context.Context, db *gorm.DB, ctx *ActionChainContext, firstActionID string) error {
+	nextActionID := firstActionID
+	chainHandlerUsed := false
+	for nextActionID != "" {
+		if err := runCtx.Err(); err != nil {
+			return fmt.Errorf("run stopped before action %s: %v", nextActionID, err)
+		}
+		nextAction, err := getActionByID(db, nextActionID)
+		if err != nil {
+			return fmt.Errorf("failed to get action %s: %v", nextActionID, err)
+		}
+		if err := r.execStep(runCtx, db, ctx, &nextAction); err != nil {
+			if errors.Is(err, errRunSuspended) {
+				return err
+			}
+			err = fmt.Errorf("failed to execute action %s: %v", nextAction.ID, err)
+
+			// Route the failure to the action's error handler, or once per run
+			// to the chain's one, instead of aborting
+			handlerID := nextAction.OnErrorActionID
+			if handlerID == "" && !chainHandlerUsed {
+				handlerID = r.errorActionID
+				chainHandlerUsed = true
+			}
+			if handlerID == "" || runCtx.Err() != nil {
+				return err
+			}
+			log.Printf("run %s: routing failure of action %s to %s", r.ID, nextAction.ID, handlerID)
+			ctx.Set(ErrorResultID, map[string]interface{}{
+				"message":     err.Error(),
+				"action_id":   nextAction.ID,
+				"action_type": nextAction.Type,
+				"run_id":      r.ID,
+			})
+			r.Error = err.Error()
+			nextActionID = handlerID
+			continue
+		}
+		nextActionID = nextAction.FollowingActionID
+	}
+	return nil
+}
+
+func (r *Run) execStep(runCtx context.Context, db *gorm.DB, ctx *ActionChainContext, action *Action) error {
+	step := RunStep{
+		RunID:      r.ID,
+		ActionID:   action.ID,
+		ActionType: action.Type,
+		Status:     RunStatusRunning,
+		StartedAt:  time.Now(),
+	}
+	if err := db.Create(&step).Error; err != nil {
+		log.Printf("failed to create step for run %s: %v", r.ID, err)
+	}
+
+	var attempts []StepAttempt
+	err := action.execWithRetry(runCtx, ctx, &attempts)
+	step.Attempts = attempts
+
+	// Only the steps of a chain's own run can suspend it, not the ones of a
+	// chain it called
+	var suspension *runSuspension
+	if errors.As(err, &suspension) && ctx.depth == 0 {
+		return r.suspend(db, ctx, action, &step, suspension)
+	}
+
+	now := time.Now()
+	step.EndedAt = &now
+	step.Status = runStatus(runCtx, err)
+	if err != nil {
+		step.Error = err.Error()
+	}
+	if action.ResultID != "" {
+		step.Result = toRawJSON(ctx.Get(action.ResultID))
+	}
+	if saveErr := db.Save(&step).Error; saveErr != nil {
+		log.Printf("failed to save step for run %s: %v", r.ID, saveErr)
+	}
+	r.Steps = append(r.Steps, step)
+
+	return err
+}
+
+// execWithRetry executes the action until it succeeds or its retry policy
+// gives up, appending each try to attempts when not nil
+func (a *Action) execWithRetry(runCtx context.Context, ctx *ActionChainContext, attempts *[]StepAttempt) error {
+	for attempt := 1; ; attempt++ {
+		attemptStart := time.Now()
+		err := a.execWithTimeout(runCtx, ctx)
+		if attempts != nil {
+			stepAttempt := StepAttempt{Attempt: attempt, StartedAt: attemptStart, EndedAt: time.Now()}
+			if err != nil {
+				stepAttempt.Error = err.Error()
+			}
+			*attempts = append(*attempts, stepAttempt)
+		}
+
+		var suspension *runSuspension
+		if err == nil || runCtx.Err() != nil || errors.As(err, &suspension) || !a.Retry.shouldRetry(attempt, err) {
+			return err
+		}
+		delay := a.Retry.backoff(attempt)
+		log.Printf("action %s failed (attempt %d), retrying in %s: %v", a.ID, attempt, delay, err)
+		if waitErr := sleepContext(runCtx, delay); waitErr != nil {
+			return err
+		}
+	}
+}
+
+// execWithTimeout executes a single attempt of the action, bounded by its timeout
+func (a *Action) execWithTimeout(runCtx context.Context, ctx *ActionChainContext) (err error) {
+	// Actions also run in goroutines of their own, e.g. in parallel branches,
+	// where a panic would otherwise take down the server
+	defer recoverPanic(&err, "action "+a.ID)
+	timeout, err := parseTimeout(a.Timeout)
+	if err != nil {
+		return err
+	}
+	if timeout > 0 {
+		var cancel context.CancelFunc
+		runCtx, cancel = context.WithTimeout(runCtx, timeout)
+		defer cancel()
+	}
+	return a.Exec(runCtx, ctx)
+}
+
+// recoverPanic turns a panic into an error stored in err, so that a faulty
+// action fails its run instead of crashing the server
+func recoverPanic(err *error, what string) {
+	if p := recover(); p != nil {
+		log.Printf("panic in %s: %v\n%s", what, p, debug.Stack())
+		*err = fmt.Errorf("panic in %s: %v", what, p)
+	}
+}
+
+// recordFilteredRun stores a payload discarded by the trigger filter in the
+// run history of the chain
+func recordFilteredRun(db *gorm.DB, chainID string, payload interface{}) error {
+	now := time.Now()
+	return db.Create(&Run{
+		ID:        utils.NewID(),
+		ChainID:   chainID,
+		Status:    RunStatusFiltered,
+		Payload:   toRawJSON(payload),
+		StartedAt: now,
+		EndedAt:   &now,
+	}).Error
+}
+
+// WaitForRuns refuses new runs, then blocks until every run in flight has
+// finished recording its outcome, or until ctx is done
+func WaitForRuns(ctx context.Context) error {
+	runsMutex.Lock()
+	runsClosed = true
+	runsMutex.Unlock()
+
+	done := make(chan struct{})
+	go func() {
+		runsInFlight.Wait()
+		close(done)
+	}()
+	select {
+	case <-done:
+		return nil
+	case <-ctx.Done():
+		return ctx.Err()
+	}
+}
+
+// runStatus maps the outcome of a run or a step to its status, telling apart
+// deactivation and shutdown from actual failures
+func runStatus(runCtx context.Context, err error) string {
+	switch {
+	case err == nil:
+		return RunStatusSucceeded
+	case errors.Is(runCtx.Err(), context.Canceled):
+		return RunStatusCancelled
+	default:
+		return RunStatusFailed
+	}
+}
+
+// parseTimeout parses an optional duration such as "30s", empty means no limit
+func parseTimeout(timeout string) (time.Duration, error) {
+	if timeout == "" {
+		return 0, nil
+	}
+	d, err := time.ParseDuration(timeout)
+	if err != nil {
+		return 0, fmt.Errorf("invalid timeout %q: %v", timeout, err)
+	}
+	return d, nil
+}
+
+// toRawJSON encodes a context value for storage in the run history
+func toRawJSON(v interface{}) json.RawMessage {
+	if v == nil {
+		return nil
+	}
+	b, err := json.Marshal(v)
+	if err != nil {
+		log.Printf("Error marshaling run value: %v", err)
+		return nil
+	}
+	return b
+}
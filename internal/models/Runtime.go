@@ -0,0 +1,290 @@
+package models
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"log"
+	"sort"
+	"sync"
+	"sync/atomic"
+	"time"
+
+	"gorm.io/gorm"
+)
+
+// Runtime tracks the chains activated on this server
+var Runtime = NewRuntimeManager()
+
+// ChainRuntime is the in-memory state of an activated chain: its running
+// trigger and the runs it started
+type ChainRuntime struct {
+	chain      *ActionChain
+	ctx        context.Context // parent of the chain's runs
+	cancelRuns context.CancelFunc
+	provider   TriggerProvider
+	slots      chan struct{} // nil when concurrent runs are unlimited
+	startedAt  time.Time
+	inFlight   atomic.Int64
+
+	mu          sync.Mutex
+	endpoint    string
+	lastError   string
+	lastErrorAt *time.Time
+}
+
+// ChainRuntimeStatus is a snapshot of a ChainRuntime
+type ChainRuntimeStatus struct {
+	ChainID     string     `json:"chain_id"`
+	TriggerType string     `json:"trigger_type"`
+	Endpoint    string     `json:"endpoint,omitempty"`
+	StartedAt   time.Time  `json:"started_at"`
+	InFlight    int64      `json:"in_flight"`
+	LastError   string     `json:"last_error,omitempty"`
+	LastErrorAt *time.Time `json:"last_error_at,omitempty"`
+}
+
+// RuntimeManager starts and stops the triggers of action chains
+type RuntimeManager struct {
+	mu     sync.RWMutex
+	chains map[string]*ChainRuntime
+	ctx    context.Context
+	cancel context.CancelFunc
+
+	stopWaits context.CancelFunc // stops resuming waiting runs, set by ResumeWaits
+}
+
+func NewRuntimeManager() *RuntimeManager {
+	ctx, cancel := context.WithCancel(context.Background())
+	return &RuntimeManager{
+		chains: make(map[string]*ChainRuntime),
+		ctx:    ctx,
+		cancel: cancel,
+	}
+}
+
+// Activate starts the trigger of the chain with the provider registered for
+// its type and tracks it until Deactivate
+func (m *RuntimeManager) Activate(chain *ActionChain, db *gorm.DB) error {
+	if chain.Trigger == nil {
+		return fmt.Errorf("action chain %s has no trigger", chain.ID)
+	}
+	provider, err := GetTriggerProvider(chain.Trigger.Type)
+	if err != nil {
+		return err
+	}
+
+	m.mu.Lock()
+	defer m.mu.Unlock()
+	if _, ok := m.chains[chain.ID]; ok {
+		return fmt.Errorf("action chain %s is already active", chain.ID)
+	}
+
+	// Runs are detached from whatever started them, they are only cancelled
+	// when the chain is deactivated or the server shuts down
+	ctx, cancel := context.WithCancel(m.ctx)
+	rt := &ChainRuntime{
+		chain:      chain,
+		ctx:        ctx,
+		cancelRuns: cancel,
+		provider:   provider,
+		startedAt:  time.Now(),
+	}
+	if chain.MaxConcurrentRuns > 0 {
+		rt.slots = make(chan struct{}, chain.MaxConcurrentRuns)
+	}
+
+	if err := provider.Start(chain.Trigger, rt, db); err != nil {
+		cancel()
+		return err
+	}
+	m.chains[chain.ID] = rt
+	return nil
+}
+
+// Deactivate stops the trigger of the chain and cancels its runs in flight
+func (m *RuntimeManager) Deactivate(chainID string) error {
+	m.mu.Lock()
+	rt, ok := m.chains[chainID]
+	delete(m.chains, chainID)
+	m.mu.Unlock()
+	if !ok {
+		return fmt.Errorf("action chain %s is not active", chainID)
+	}
+
+	rt.stopTrigger()
+	rt.cancelRuns()
+	return nil
+}
+
+// List returns the status of every active chain, ordered by chain ID
+func (m *RuntimeManager) List() []ChainRuntimeStatus {
+	m.mu.RLock()
+	defer m.mu.RUnlock()
+	list := make([]ChainRuntimeStatus, 0, len(m.chains))
+	for _, rt := range m.chains {
+		list = append(list, rt.status())
+	}
+	sort.Slice(list, func(i, j int) bool { return list[i].ChainID < list[j].ChainID })
+	return list
+}
+
+// Shutdown stops every trigger so no new run starts, lets the runs in flight
+// finish until ctx is done, then cancels the remaining ones
+func (m *RuntimeManager) Shutdown(ctx context.Context) error {
+	m.mu.Lock()
+	chains := m.chains
+	m.chains = make(map[string]*ChainRuntime)
+	stopWaits := m.stopWaits
+	m.mu.Unlock()
+
+	for id, rt := range chains {
+		log.Printf("Stopping trigger of action chain %s", id)
+		rt.stopTrigger()
+	}
+	if stopWaits != nil {
+		stopWaits()
+	}
+
+	err := WaitForRuns(ctx)
+	m.cancel()
+	if err != nil {
+		// Give cancelled runs a moment to record their outcome
+		graceCtx, cancel := context.WithTimeout(context.Background(), 2*time.Second)
+		defer cancel()
+		WaitForRuns(graceCtx)
+	}
+	return err
+}
+
+// RunNow executes the chain synchronously with payload stored as the trigger
+// result, without starting its trigger. The payload of webhook chains is
+// stored as the body of a request. The run is cancelled on shutdown. When it
+// suspends, it resumes once the chain is active.
+func (m *RuntimeManager) RunNow(db *gorm.DB, chain *ActionChain, payload interface{}) (*Run, *ActionChainContext, error) {
+	if chain.Trigger == nil {
+		return nil, nil, fmt.Errorf("action chain %s has no trigger", chain.ID)
+	}
+	payload = chain.Trigger.runPayload(payload)
+	ctx := NewRunContext(chain.Context)
+	ctx.Set(chain.Trigger.ResultID, payload)
+
+	run, err := StartRun(m.ctx, db, chain, ctx, chain.Trigger.FollowingActionID, payload)
+	return run, ctx, err
+}
+
+// ErrRunFiltered is returned by Fire when the trigger filter discards the payload
+var ErrRunFiltered = errors.New("payload discarded by the trigger filter")
+
+// ErrTooManyRuns is returned by Fire when the chain already runs
+// MaxConcurrentRuns times
+var ErrTooManyRuns = errors.New("too many concurrent runs")
+
+// Chain returns the activated chain
+func (rt *ChainRuntime) Chain() *ActionChain {
+	return rt.chain
+}
+
+// Context returns the context of the chain's activation, done when the chain
+// is deactivated or the server shuts down
+func (rt *ChainRuntime) Context() context.Context {
+	return rt.ctx
+}
+
+// Fire starts a run of the chain in the background with payload as the
+// trigger result. It returns ErrRunFiltered or ErrTooManyRuns when no run
+// starts.
+func (rt *ChainRuntime) Fire(db *gorm.DB, payload interface{}) error {
+	return rt.fire(db, payload, 0)
+}
+
+// fire is Fire for a run that chain triggers started hops runs in a row
+func (rt *ChainRuntime) fire(db *gorm.DB, payload interface{}, hops int) error {
+	trigger := rt.chain.Trigger
+	ctx := NewRunContext(rt.chain.Context)
+	ctx.hops = hops
+	ctx.Set(trigger.ResultID, payload)
+	if !rt.accepts(db, ctx, payload) {
+		return ErrRunFiltered
+	}
+
+	release, ok := rt.acquire()
+	if !ok {
+		return ErrTooManyRuns
+	}
+	go func() {
+		defer release()
+		rt.startRun(db, ctx, trigger.FollowingActionID, payload)
+	}()
+	return nil
+}
+
+func (rt *ChainRuntime) stopTrigger() {
+	if err := rt.provider.Stop(rt.chain.ID); err != nil {
+		log.Printf("Failed to stop trigger of action chain %s: %v", rt.chain.ID, err)
+	}
+}
+
+func (rt *ChainRuntime) status() ChainRuntimeStatus {
+	rt.mu.Lock()
+	defer rt.mu.Unlock()
+	return ChainRuntimeStatus{
+		ChainID:     rt.chain.ID,
+		TriggerType: rt.chain.Trigger.Type,
+		Endpoint:    rt.endpoint,
+		StartedAt:   rt.startedAt,
+		InFlight:    rt.inFlight.Load(),
+		LastError:   rt.lastError,
+		LastErrorAt: rt.lastErrorAt,
+	}
+}
+
+// SetEndpoint records where the trigger listens, for the runtime listing
+func (rt *ChainRuntime) SetEndpoint(endpoint string) {
+	rt.mu.Lock()
+	defer rt.mu.Unlock()
+	rt.endpoint = endpoint
+}
+
+// RecordError keeps the last trigger or run error, for the runtime listing
+func (rt *ChainRuntime) RecordError(err error) {
+	now := time.Now()
+	rt.mu.Lock()
+	defer rt.mu.Unlock()
+	rt.lastError = err.Error()
+	rt.lastErrorAt = &now
+}
+
+// acquire reserves a run slot, it returns false when the chain already runs
+// MaxConcurrentRuns times
+func (rt *ChainRuntime) acquire() (release func(), ok bool) {
+	if rt.slots == nil {
+		return func() {}, true
+	}
+	select {
+	case rt.slots <- struct{}{}:
+		return func() { <-rt.slots }, true
+	default:
+		return nil, false
+	}
+}
+
+// startRun runs the chain from firstActionID with the given context, if any
+func (rt *ChainRuntime) startRun(db *gorm.DB, ctx *ActionChainContext, firstActionID string, payload interface{}) (*Run, error) {
+	if firstActionID == "" {
+		return nil, nil
+	}
+	rt.inFlight.Add(1)
+	defer rt.inFlight.Add(-1)
+
+	run, err := StartRun(rt.ctx, db, rt.chain, ctx, firstActionID, payload)
+	if err != nil {
+		rt.RecordError(err)
+		if run != nil {
+			log.Printf("run %s of chain %s failed: %v", run.ID, rt.chain.ID, err)
+		} else {
+			log.Printf("failed to start run of chain %s: %v", rt.chain.ID, err)
+		}
+	}
+	return run, err
+}
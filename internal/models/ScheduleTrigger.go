@@ -0,0 +1,127 @@
+package models
+
+import (
+	"encoding/json"
+	"errors"
+	"fmt"
+	"log"
+	"time"
+
+	"gorm.io/gorm"
+)
+
+const (
+	MissedFireSkip     = "skip"      // fires missed while the server was down are dropped
+	MissedFireFireOnce = "fire_once" // a single run catches up for all missed fires
+)
+
+// ScheduleConfig configures a "schedule" trigger. The trigger Body, when set,
+// is parsed as JSON and passed to every run along with the fire times.
+type ScheduleConfig struct {
+	Cron             string     `json:"cron"`                         // "sec min hour dom month dow", 5 field expressions fire at second 0
+	Timezone         string     `json:"timezone,omitempty"`           // IANA name such as "Europe/Paris", defaults to UTC
+	MissedFirePolicy string     `json:"missed_fire_policy,omitempty"` // "skip" (default) or "fire_once"
+	NextFireAt       *time.Time `json:"next_fire_at,omitempty"`       // maintained by the trigger
+	LastFireAt       *time.Time `json:"last_fire_at,omitempty"`       // maintained by the trigger
+}
+
+// execSchedule starts runs of the chain on the trigger's cron schedule
+func (t *Trigger) execSchedule(rt *ChainRuntime, db *gorm.DB) (stop func(), err error) {
+	cfg := t.Schedule
+	if cfg == nil {
+		return nil, fmt.Errorf("schedule trigger requires a schedule")
+	}
+	schedule, err := parseCron(cfg.Cron)
+	if err != nil {
+		return nil, err
+	}
+	loc := time.UTC
+	if cfg.Timezone != "" {
+		if loc, err = time.LoadLocation(cfg.Timezone); err != nil {
+			return nil, fmt.Errorf("invalid timezone %q: %v", cfg.Timezone, err)
+		}
+	}
+	switch cfg.MissedFirePolicy {
+	case "", MissedFireSkip, MissedFireFireOnce:
+	default:
+		return nil, fmt.Errorf("invalid missed fire policy %q", cfg.MissedFirePolicy)
+	}
+	var body interface{}
+	if t.Body != "" {
+		if err := json.Unmarshal([]byte(t.Body), &body); err != nil {
+			return nil, fmt.Errorf("invalid schedule trigger body: %v", err)
+		}
+	}
+
+	rt.SetEndpoint(fmt.Sprintf("cron %q (%s)", cfg.Cron, loc))
+	fmt.Printf("Scheduling action chain %s on %q (%s)...\n", rt.chain.ID, cfg.Cron, loc)
+
+	fire := func(scheduledAt time.Time, missed bool) {
+		now := time.Now()
+		cfg.LastFireAt = &now
+
+		payload := map[string]interface{}{
+			"scheduled_at": scheduledAt.Format(time.RFC3339),
+			"fired_at":     now.In(loc).Format(time.RFC3339),
+			"missed":       missed,
+			"body":         body,
+		}
+		if err := rt.Fire(db, payload); errors.Is(err, ErrTooManyRuns) {
+			log.Printf("Skipping scheduled run of chain %s: too many concurrent runs", rt.chain.ID)
+			rt.RecordError(fmt.Errorf("scheduled run at %s skipped: too many concurrent runs", scheduledAt.Format(time.RFC3339)))
+		}
+	}
+
+	done := make(chan struct{})
+	go func() {
+		// Fires that were due while the server was down
+		if cfg.NextFireAt != nil && cfg.NextFireAt.Before(time.Now()) {
+			if cfg.MissedFirePolicy == MissedFireFireOnce {
+				log.Printf("Chain %s missed its run scheduled at %s, firing once", rt.chain.ID, cfg.NextFireAt.Format(time.RFC3339))
+				fire(cfg.NextFireAt.In(loc), true)
+			} else {
+				log.Printf("Chain %s missed its run scheduled at %s, skipping", rt.chain.ID, cfg.NextFireAt.Format(time.RFC3339))
+			}
+		}
+
+		last := time.Now().In(loc)
+		for {
+			from := time.Now().In(loc)
+			if from.Before(last) {
+				from = last
+			}
+			next := schedule.next(from)
+			if next.IsZero() {
+				rt.RecordError(fmt.Errorf("cron %q never fires", cfg.Cron))
+				return
+			}
+			cfg.NextFireAt = &next
+			saveSchedule(db, rt.chain.ID, cfg)
+
+			timer := time.NewTimer(time.Until(next))
+			select {
+			case <-done:
+				timer.Stop()
+				return
+			case <-timer.C:
+				fire(next, false)
+				last = next
+			}
+		}
+	}()
+
+	return func() { close(done) }, nil
+}
+
+// saveSchedule persists the fire times so missed fires are detected on restart
+func saveSchedule(db *gorm.DB, chainID string, cfg *ScheduleConfig) {
+	data, err := json.Marshal(cfg)
+	if err != nil {
+		log.Printf("Error encoding schedule of chain %s: %v", chainID, err)
+		return
+	}
+	err = db.Model(&ActionChain{}).Where("id = ?", chainID).Update("schedule", string(data)).Error
+	if err != nil {
+		log.Printf("Error saving schedule of chain %s: %v", chainID, err)
+	}
+}
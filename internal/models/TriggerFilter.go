@@ -0,0 +1,47 @@
+package models
+
+import (
+	"fmt"
+	"log"
+
+	"gorm.io/gorm"
+)
+
+// TriggerFilter discards the trigger payloads a chain does not handle, such as
+// the other event types sent to a shared webhook endpoint
+type TriggerFilter struct {
+	Condition    string                  `json:"condition"`              // same format as if_then, e.g. "[[event_type]] == invoice.paid"
+	Placeholders map[string]*Placeholder `json:"placeholders,omitempty"` // resolved against the context holding the trigger result
+	Record       bool                    `json:"record,omitempty"`       // keep discarded payloads in the run history as "filtered" runs
+}
+
+func (f *TriggerFilter) matches(ctx *ActionChainContext) (bool, error) {
+	condition, err := processTemplate(ctx, f.Placeholders, f.Condition)
+	if err != nil {
+		return false, err
+	}
+	return evaluateCondition(condition)
+}
+
+// accepts applies the trigger filter of the chain to a payload already stored
+// in ctx. Payloads are discarded when the condition cannot be evaluated.
+func (rt *ChainRuntime) accepts(db *gorm.DB, ctx *ActionChainContext, payload interface{}) bool {
+	filter := rt.chain.Trigger.Filter
+	if filter == nil {
+		return true
+	}
+	ok, err := filter.matches(ctx)
+	if err != nil {
+		log.Printf("Failed to evaluate trigger filter of chain %s: %v", rt.chain.ID, err)
+		rt.RecordError(fmt.Errorf("trigger filter: %v", err))
+	}
+	if ok {
+		return true
+	}
+	if filter.Record {
+		if err := recordFilteredRun(db, rt.chain.ID, payload); err != nil {
+			log.Printf("Failed to record filtered run of chain %s: %v", rt.chain.ID, err)
+		}
+	}
+	return false
+}
@@ -0,0 +1,133 @@
+package models
+
+import (
+	"fmt"
+	"sort"
+	"sync"
+
+	"gorm.io/gorm"
+)
+
+// TriggerProvider starts and stops the triggers of one type. Providers are
+// shared by every chain whose trigger has that type, Start and Stop are
+// called once per activation of a chain.
+type TriggerProvider interface {
+	// Start begins delivering events of the trigger to rt, typically with
+	// rt.Fire, until Stop is called for the chain. An error leaves the chain
+	// inactive.
+	Start(t *Trigger, rt *ChainRuntime, db *gorm.DB) error
+	// Stop stops the trigger of the chain, runs in flight are not affected
+	Stop(chainID string) error
+	// Describe returns a short description of the trigger type
+	Describe() string
+}
+
+// DefaultTriggerType is used for triggers without a type
+const DefaultTriggerType = "webhook"
+
+var (
+	triggerProviders      = make(map[string]TriggerProvider)
+	triggerProvidersMutex sync.RWMutex
+)
+
+func init() {
+	RegisterTriggerProvider("webhook", NewTriggerProvider("HTTP requests on /hooks/{chain_id} or a dedicated listener", (*Trigger).execWebhook))
+	RegisterTriggerProvider("schedule", NewTriggerProvider("Cron schedule", (*Trigger).execSchedule))
+	RegisterTriggerProvider("poll", NewTriggerProvider("New items returned by an HTTP endpoint polled on an interval", (*Trigger).execPoll))
+	RegisterTriggerProvider("file", NewTriggerProvider("Files created or modified in a local directory", (*Trigger).execFile))
+	RegisterTriggerProvider("email", NewTriggerProvider("Email received by an embedded SMTP server", (*Trigger).execEmail))
+	RegisterTriggerProvider("chain", NewTriggerProvider("Completed runs of another action chain", (*Trigger).execChain))
+	RegisterTriggerProvider("queue", NewTriggerProvider("Messages of the internal job queue", (*Trigger).execQueue))
+}
+
+// RegisterTriggerProvider makes a trigger type available to action chains.
+// Registering an existing type replaces its provider for later activations.
+func RegisterTriggerProvider(triggerType string, provider TriggerProvider) {
+	triggerProvidersMutex.Lock()
+	defer triggerProvidersMutex.Unlock()
+	triggerProviders[triggerType] = provider
+}
+
+// GetTriggerProvider returns the provider registered for the trigger type
+func GetTriggerProvider(triggerType string) (TriggerProvider, error) {
+	if triggerType == "" {
+		triggerType = DefaultTriggerType
+	}
+	triggerProvidersMutex.RLock()
+	defer triggerProvidersMutex.RUnlock()
+	provider, ok := triggerProviders[triggerType]
+	if !ok {
+		return nil, fmt.Errorf("unknown trigger type: %s", triggerType)
+	}
+	return provider, nil
+}
+
+// TriggerTypeInfo describes a registered trigger type
+type TriggerTypeInfo struct {
+	Type        string `json:"type"`
+	Description string `json:"description"`
+}
+
+// ListTriggerTypes returns the registered trigger types, ordered by type
+func ListTriggerTypes() []TriggerTypeInfo {
+	triggerProvidersMutex.RLock()
+	defer triggerProvidersMutex.RUnlock()
+	list := make([]TriggerTypeInfo, 0, len(triggerProviders))
+	for triggerType, provider := range triggerProviders {
+		list = append(list, TriggerTypeInfo{Type: triggerType, Description: provider.Describe()})
+	}
+	sort.Slice(list, func(i, j int) bool { return list[i].Type < list[j].Type })
+	return list
+}
+
+// TriggerStartFunc starts a trigger and returns the function that stops it
+type TriggerStartFunc func(t *Trigger, rt *ChainRuntime, db *gorm.DB) (stop func(), err error)
+
+// NewTriggerProvider returns a provider that keeps the stop function returned
+// by start for each chain
+func NewTriggerProvider(description string, start TriggerStartFunc) TriggerProvider {
+	return &funcTriggerProvider{
+		description: description,
+		start:       start,
+		stops:       make(map[string]func()),
+	}
+}
+
+type funcTriggerProvider struct {
+	description string
+	start       TriggerStartFunc
+
+	mu    sync.Mutex
+	stops map[string]func()
+}
+
+func (p *funcTriggerProvider) Start(t *Trigger, rt *ChainRuntime, db *gorm.DB) error {
+	chainID := rt.Chain().ID
+	p.mu.Lock()
+	defer p.mu.Unlock()
+	if _, ok := p.stops[chainID]; ok {
+		return fmt.Errorf("trigger of action chain %s is already running", chainID)
+	}
+	stop, err := p.start(t, rt, db)
+	if err != nil {
+		return err
+	}
+	p.stops[chainID] = stop
+	return nil
+}
+
+func (p *funcTriggerProvider) Stop(chainID string) error {
+	p.mu.Lock()
+	stop, ok := p.stops[chainID]
+	delete(p.stops, chainID)
+	p.mu.Unlock()
+	if !ok {
+		return fmt.Errorf("trigger of action chain %s is not running", chainID)
+	}
+	stop()
+	return nil
+}
+
+func (p *funcTriggerProvider) Describe() string {
+	return p.description
+}
@@ -0,0 +1,324 @@
+package models
+
+import (
+	"context"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"log"
+	"time"
+
+	"longboy/internal/utils"
+
+	"gorm.io/gorm"
+)
+
+const (
+	WaitStatusPending   = "pending"
+	WaitStatusResumed   = "resumed"
+	WaitStatusCancelled = "cancelled"
+)
+
+const waitPollInterval = time.Second
+
+// ErrWaitNotPending is returned when acting on a wait that already ended
+var ErrWaitNotPending = errors.New("wait is no longer pending")
+
+// ErrChainNotActive is returned when a waiting run cannot resume because its
+// chain is not active
+var ErrChainNotActive = errors.New("action chain is not active")
+
+// errRunSuspended is returned by execActions once a step suspended the run
+// and its wait is stored
+var errRunSuspended = errors.New("run suspended")
+
+// RunWait is a run suspended by one of its steps. The run resumes from that
+// step at ResumeAt, or earlier when something else ends the wait.
+type RunWait struct {
+	ID        string          `json:"id" gorm:"primaryKey"`
+	RunID     string          `json:"run_id" gorm:"type:varchar(100);index"`
+	ChainID   string          `json:"chain_id" gorm:"type:varchar(100);index"`
+	StepID    uint            `json:"step_id"`
+	ActionID  string          `json:"action_id" gorm:"type:varchar(100)"`
+	Kind      string          `json:"kind" gorm:"type:varchar(50)"` // type of the action that suspended the run
+	Status    string          `json:"status" gorm:"type:varchar(20);index"`
+	ResumeAt  *time.Time      `json:"resume_at,omitempty" gorm:"index"` // nil when only an outside event ends the wait
+	Context   json.RawMessage `json:"-" gorm:"type:text"`               // results of the run when it was suspended
+	CreatedAt time.Time       `json:"created_at"`
+	EndedAt   *time.Time      `json:"ended_at,omitempty"`
+}
+
+// runSuspension is returned by actions that pause the run. Only the steps of
+// a run turn it into a RunWait, anywhere else it fails like any error.
+type runSuspension struct {
+	kind     string
+	resumeAt *time.Time
+	store    func(tx *gorm.DB, w *RunWait) error // stores what the action needs along with the wait
+}
+
+func (s *runSuspension) Error() string {
+	return fmt.Sprintf("%s action can only pause a run between the steps of a chain, not inside parallel, foreach or call_chain", s.kind)
+}
+
+// suspend stores the wait of a suspended step along with the results of the
+// run, and returns errRunSuspended
+func (r *Run) suspend(db *gorm.DB, ctx *ActionChainContext, action *Action, step *RunStep, s *runSuspension) error {
+	snapshot, err := json.Marshal(NewRunContext(ctx).Results)
+	if err != nil {
+		return fmt.Errorf("failed to store the context of the run: %v", err)
+	}
+	step.Status = RunStatusWaiting
+	wait := &RunWait{
+		ID:        utils.NewID(),
+		RunID:     r.ID,
+		ChainID:   r.ChainID,
+		StepID:    step.ID,
+		ActionID:  action.ID,
+		Kind:      s.kind,
+		Status:    WaitStatusPending,
+		ResumeAt:  s.resumeAt,
+		Context:   snapshot,
+		CreatedAt: time.Now(),
+	}
+	err = db.Transaction(func(tx *gorm.DB) error {
+		if err := tx.Save(step).Error; err != nil {
+			return err
+		}
+		if err := tx.Create(wait).Error; err != nil {
+			return err
+		}
+		if s.store != nil {
+			return s.store(tx, wait)
+		}
+		return nil
+	})
+	if err != nil {
+		return fmt.Errorf("failed to suspend run: %v", err)
+	}
+	r.Steps = append(r.Steps, *step)
+	log.Printf("run %s: action %s suspended the run (wait %s)", r.ID, action.ID, wait.ID)
+	return errRunSuspended
+}
+
+// resume completes the step that suspended the run and returns the action
+// the run goes on with
+func (a *Action) resume(ctx *ActionChainContext, w *RunWait, now time.Time) (string, error) {
+	switch a.Type {
+	case "delay":
+		return a.resumeDelay(ctx, w, now)
+	case "approval":
+		return a.resumeApproval(ctx, w, now)
+	default:
+		return "", fmt.Errorf("action type %s cannot resume a run", a.Type)
+	}
+}
+
+// endWait marks a pending wait as resumed or cancelled. It returns
+// ErrWaitNotPending when the wait already ended.
+func endWait(db *gorm.DB, id, status string) (*RunWait, error) {
+	var wait RunWait
+	if err := db.First(&wait, "id = ?", id).Error; err != nil {
+		return nil, err
+	}
+	now := time.Now()
+	result := db.Model(&RunWait{}).Where("id = ? AND status = ?", id, WaitStatusPending).
+		Updates(map[string]interface{}{"status": status, "ended_at": now})
+	if result.Error != nil {
+		return nil, result.Error
+	}
+	if result.RowsAffected == 0 {
+		return nil, ErrWaitNotPending
+	}
+	wait.Status = status
+	wait.EndedAt = &now
+	return &wait, nil
+}
+
+// ResumeWaits resumes the suspended runs whose wait is over, checking every
+// second until Shutdown. Waits that ended while the server was down resume
+// right away. Waits of inactive chains stay pending until the chain is
+// activated again, and those of busy chains until a run slot frees up.
+func (m *RuntimeManager) ResumeWaits(db *gorm.DB) {
+	ctx, cancel := context.WithCancel(m.ctx)
+	m.mu.Lock()
+	m.stopWaits = cancel
+	m.mu.Unlock()
+
+	go func() {
+		ticker := time.NewTicker(waitPollInterval)
+		defer ticker.Stop()
+		for {
+			if err := m.resumeDueWaits(ctx, db); err != nil && ctx.Err() == nil {
+				log.Printf("Failed to resume waiting runs: %v", err)
+			}
+			select {
+			case <-ctx.Done():
+				return
+			case <-ticker.C:
+			}
+		}
+	}()
+}
+
+func (m *RuntimeManager) resumeDueWaits(ctx context.Context, db *gorm.DB) error {
+	var due []RunWait
+	err := db.Where("status = ? AND resume_at <= ?", WaitStatusPending, time.Now()).Order("resume_at").Find(&due).Error
+	if err != nil {
+		return err
+	}
+	for _, w := range due {
+		if ctx.Err() != nil {
+			return nil
+		}
+		rt, done, err := m.reserveResume(w.ChainID)
+		if errors.Is(err, ErrShuttingDown) {
+			return nil
+		}
+		if err != nil {
+			continue
+		}
+		wait, err := endWait(db, w.ID, WaitStatusResumed)
+		if errors.Is(err, ErrWaitNotPending) {
+			done()
+			continue
+		}
+		if err != nil {
+			done()
+			return err
+		}
+		rt.resumeRun(db, wait, done)
+	}
+	return nil
+}
+
+// reserveResume takes a run slot of the active chain of a wait and counts
+// the run with beginRun before the wait ends, so that a wait never ends
+// without its run resuming. done gives both back, the caller calls it when
+// the wait could not be ended. It returns ErrChainNotActive, ErrTooManyRuns
+// or ErrShuttingDown when the run cannot resume now.
+func (m *RuntimeManager) reserveResume(chainID string) (rt *ChainRuntime, done func(), err error) {
+	m.mu.RLock()
+	rt, ok := m.chains[chainID]
+	m.mu.RUnlock()
+	if !ok {
+		return nil, nil, fmt.Errorf("%w: %s", ErrChainNotActive, chainID)
+	}
+	release, ok := rt.acquire()
+	if !ok {
+		return nil, nil, ErrTooManyRuns
+	}
+	if !beginRun() {
+		release()
+		return nil, nil, ErrShuttingDown
+	}
+	return rt, func() {
+		endRun()
+		release()
+	}, nil
+}
+
+// resumeRun continues a suspended run in the background from the step that
+// suspended it, as a run of the chain: it counts as in flight and is
+// cancelled when the chain is deactivated. done is called once it ends.
+func (rt *ChainRuntime) resumeRun(db *gorm.DB, w *RunWait, done func()) {
+	rt.inFlight.Add(1)
+	go func() {
+		defer done()
+		defer rt.inFlight.Add(-1)
+		if err := continueRun(rt.ctx, db, w); err != nil {
+			rt.RecordError(err)
+			log.Printf("run %s resumed from wait %s failed: %v", w.RunID, w.ID, err)
+		}
+	}()
+}
+
+func continueRun(runCtx context.Context, db *gorm.DB, w *RunWait) error {
+	run, ctx, err := loadWaitingRun(db, w)
+	if err != nil {
+		return err
+	}
+	var chain ActionChain
+	if err := db.First(&chain, "id = ?", w.ChainID).Error; err != nil {
+		return run.finish(runCtx, db, ctx, fmt.Errorf("failed to retrieve action chain %s: %v", w.ChainID, err))
+	}
+	run.errorActionID = chain.ErrorActionID
+	run.Status = RunStatusRunning
+	if err := db.Omit("Steps").Save(run).Error; err != nil {
+		log.Printf("failed to save run %s: %v", run.ID, err)
+	}
+
+	now := time.Now()
+	action, err := getActionByID(db, w.ActionID)
+	var nextActionID string
+	if err == nil {
+		nextActionID, err = action.resume(ctx, w, now)
+	} else {
+		err = fmt.Errorf("failed to get action %s: %v", w.ActionID, err)
+	}
+	step := RunStep{ID: w.StepID}
+	stepUpdates := map[string]interface{}{"status": runStatus(runCtx, err), "ended_at": now}
+	if err != nil {
+		stepUpdates["error"] = err.Error()
+	} else if action.ResultID != "" {
+		stepUpdates["result"] = toRawJSON(ctx.Get(action.ResultID))
+	}
+	if saveErr := db.Model(&step).Updates(stepUpdates).Error; saveErr != nil {
+		log.Printf("failed to save step for run %s: %v", run.ID, saveErr)
+	}
+
+	if err == nil {
+		err = run.execWithTimeout(runCtx, db, &chain, ctx, nextActionID)
+	} else {
+		err = fmt.Errorf("failed to resume action %s: %v", w.ActionID, err)
+	}
+	return run.finish(runCtx, db, ctx, err)
+}
+
+// loadWaitingRun retrieves the run of a wait with the context it had when it
+// was suspended
+func loadWaitingRun(db *gorm.DB, w *RunWait) (*Run, *ActionChainContext, error) {
+	var run Run
+	if err := db.First(&run, "id = ?", w.RunID).Error; err != nil {
+		return nil, nil, fmt.Errorf("failed to retrieve run %s: %v", w.RunID, err)
+	}
+	ctx := &ActionChainContext{Results: make(map[string]interface{}), db: db, hops: run.Hops}
+	if len(w.Context) > 0 {
+		if err := json.Unmarshal(w.Context, &ctx.Results); err != nil {
+			return nil, nil, fmt.Errorf("failed to restore the context of run %s: %v", w.RunID, err)
+		}
+	}
+	return &run, ctx, nil
+}
+
+// CancelWait ends a pending wait and cancels its run
+func CancelWait(db *gorm.DB, id string) (*RunWait, error) {
+	var wait *RunWait
+	err := db.Transaction(func(tx *gorm.DB) error {
+		var err error
+		if wait, err = endWait(tx, id, WaitStatusCancelled); err != nil {
+			return err
+		}
+		if wait.Kind == "approval" {
+			return tx.Model(&Approval{}).Where("id = ? AND status = ?", wait.ID, ApprovalStatusPending).
+				Update("status", ApprovalStatusCancelled).Error
+		}
+		return nil
+	})
+	if err != nil {
+		return nil, err
+	}
+	run, ctx, err := loadWaitingRun(db, wait)
+	if err != nil {
+		return wait, err
+	}
+	now := time.Now()
+	db.Model(&RunStep{ID: wait.StepID}).Updates(map[string]interface{}{"status": RunStatusCancelled, "ended_at": now})
+	run.Status = RunStatusCancelled
+	run.Error = fmt.Sprintf("wait %s cancelled", wait.ID)
+	run.EndedAt = &now
+	if err := db.Omit("Steps").Save(run).Error; err != nil {
+		return wait, fmt.Errorf("failed to save run %s: %v", run.ID, err)
+	}
+	publishRunCompleted(run, ctx)
+	return wait, nil
+}
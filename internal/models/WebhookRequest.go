@@ -0,0 +1,202 @@
+package models
+
+import (
+	"bytes"
+	"encoding/base64"
+	"encoding/json"
+	"fmt"
+	"io"
+	"mime"
+	"mime/multipart"
+	"net/http"
+	"net/url"
+	"strings"
+	"unicode/utf8"
+)
+
+// newWebhookRequest builds the result of a webhook trigger from the request:
+// method, path relative to the webhook mount, headers, query, path parameters,
+// the body parsed according to its content type and the raw body
+func newWebhookRequest(r *http.Request, path string, body []byte, pathParams map[string]string) (map[string]interface{}, error) {
+	parsedBody, err := parseRequestBody(r.Header.Get("Content-Type"), body)
+	if err != nil {
+		return nil, err
+	}
+
+	headers := make(map[string]interface{}, len(r.Header))
+	for name, values := range r.Header {
+		headers[name] = strings.Join(values, ", ")
+	}
+	params := make(map[string]interface{}, len(pathParams))
+	for name, value := range pathParams {
+		params[name] = value
+	}
+
+	return map[string]interface{}{
+		"method":      r.Method,
+		"path":        path,
+		"headers":     headers,
+		"query":       valuesToMap(r.URL.Query()),
+		"path_params": params,
+		"body":        parsedBody,
+		"raw_body":    string(body),
+	}, nil
+}
+
+// runPayload returns the trigger result of a run started without its trigger,
+// through the API or a call_chain action. Webhook chains get the payload
+// wrapped as the body of a request, so their templates resolve the same way
+// as when the webhook fires.
+func (t *Trigger) runPayload(payload interface{}) interface{} {
+	if t.Type != "" && t.Type != "webhook" {
+		return payload
+	}
+	path := "/"
+	if parsedURL, err := url.Parse(t.URL); err == nil && parsedURL.Path != "" {
+		path = parsedURL.Path
+	}
+	method := t.Method
+	if method == "" {
+		method = http.MethodPost
+	}
+	rawBody := ""
+	if payload != nil {
+		rawBody = string(toRawJSON(payload))
+	}
+	return map[string]interface{}{
+		"method":      method,
+		"path":        path,
+		"headers":     map[string]interface{}{},
+		"query":       map[string]interface{}{},
+		"path_params": map[string]interface{}{},
+		"body":        payload,
+		"raw_body":    rawBody,
+	}
+}
+
+// parseRequestBody decodes JSON, form-urlencoded and multipart bodies. Other
+// bodies are decoded as JSON when valid and kept as text otherwise.
+func parseRequestBody(contentType string, body []byte) (interface{}, error) {
+	if len(body) == 0 {
+		return nil, nil
+	}
+	mediaType, params, _ := mime.ParseMediaType(contentType)
+
+	switch {
+	case mediaType == "application/json" || strings.HasSuffix(mediaType, "+json"):
+		var data interface{}
+		if err := json.Unmarshal(body, &data); err != nil {
+			return nil, fmt.Errorf("error parsing JSON: %v", err)
+		}
+		return data, nil
+	case mediaType == "application/x-www-form-urlencoded":
+		values, err := url.ParseQuery(string(body))
+		if err != nil {
+			return nil, fmt.Errorf("error parsing form: %v", err)
+		}
+		return valuesToMap(values), nil
+	case strings.HasPrefix(mediaType, "multipart/"):
+		return parseMultipart(body, params["boundary"])
+	default:
+		var data interface{}
+		if json.Unmarshal(body, &data) == nil {
+			return data, nil
+		}
+		return string(body), nil
+	}
+}
+
+// parseMultipart returns the fields of a multipart body, files are returned as
+// objects with their name, content type, size and content
+func parseMultipart(body []byte, boundary string) (map[string]interface{}, error) {
+	if boundary == "" {
+		return nil, fmt.Errorf("error parsing multipart body: missing boundary")
+	}
+	fields := make(map[string]interface{})
+	reader := multipart.NewReader(bytes.NewReader(body), boundary)
+	for {
+		part, err := reader.NextPart()
+		if err == io.EOF {
+			break
+		}
+		if err != nil {
+			return nil, fmt.Errorf("error parsing multipart body: %v", err)
+		}
+		content, err := io.ReadAll(part)
+		part.Close()
+		if err != nil {
+			return nil, fmt.Errorf("error parsing multipart body: %v", err)
+		}
+
+		var value interface{} = string(content)
+		if part.FileName() != "" {
+			file := map[string]interface{}{
+				"filename":     part.FileName(),
+				"content_type": part.Header.Get("Content-Type"),
+				"size":         len(content),
+			}
+			if utf8.Valid(content) {
+				file["content"] = string(content)
+			} else {
+				file["content"] = base64.StdEncoding.EncodeToString(content)
+				file["encoding"] = "base64"
+			}
+			value = file
+		}
+		addField(fields, part.FormName(), value)
+	}
+	return fields, nil
+}
+
+// valuesToMap keeps single values as strings and repeated ones as lists
+func valuesToMap(values map[string][]string) map[string]interface{} {
+	m := make(map[string]interface{}, len(values))
+	for name, list := range values {
+		for _, value := range list {
+			addField(m, name, value)
+		}
+	}
+	return m
+}
+
+func addField(fields map[string]interface{}, name string, value interface{}) {
+	existing, ok := fields[name]
+	if !ok {
+		fields[name] = value
+		return
+	}
+	if list, ok := existing.([]interface{}); ok {
+		fields[name] = append(list, value)
+		return
+	}
+	fields[name] = []interface{}{existing, value}
+}
+
+// matchPath matches a request path against a pattern such as "/orders/{id}"
+// and returns the values of the pattern's parameters
+func matchPath(pattern, path string) (map[string]string, bool) {
+	patternParts := strings.Split(strings.Trim(pattern, "/"), "/")
+	pathParts := strings.Split(strings.Trim(path, "/"), "/")
+	if len(patternParts) != len(pathParts) {
+		return nil, false
+	}
+
+	params := make(map[string]string)
+	for i, part := range patternParts {
+		if strings.HasPrefix(part, "{") && strings.HasSuffix(part, "}") {
+			if pathParts[i] == "" {
+				return nil, false
+			}
+			value, err := url.PathUnescape(pathParts[i])
+			if err != nil {
+				return nil, false
+			}
+			params[part[1:len(part)-1]] = value
+			continue
+		}
+		if part != pathParts[i] {
+			return nil, false
+		}
+	}
+	return params, true
+}
@@ -0,0 +1,85 @@
+package models
+
+import (
+	"encoding/json"
+	"log"
+	"net/http"
+	"time"
+)
+
+// defaultResponseTimeout bounds how long a synchronous webhook waits for its run
+const defaultResponseTimeout = 30 * time.Second
+
+// WebhookResponse turns a webhook trigger into a synchronous API: the caller
+// waits for the run and receives its result instead of an acknowledgement
+type WebhookResponse struct {
+	ResultID     string                  `json:"result_id,omitempty"`    // context value returned as the body
+	Body         string                  `json:"body,omitempty"`         // templated body, takes precedence over result_id
+	Placeholders map[string]*Placeholder `json:"placeholders,omitempty"` // placeholders used by body and headers
+	StatusCode   int                     `json:"status_code,omitempty"`  // defaults to 200
+	Headers      map[string]string       `json:"headers,omitempty"`
+	Timeout      string                  `json:"timeout,omitempty"` // defaults to 30s
+}
+
+func (resp *WebhookResponse) write(w http.ResponseWriter, ctx *ActionChainContext, run *Run, runErr error) {
+	if run != nil {
+		w.Header().Set("X-Run-ID", run.ID)
+	}
+	if runErr != nil {
+		w.Header().Set("Content-Type", "application/json")
+		w.WriteHeader(http.StatusInternalServerError)
+		json.NewEncoder(w).Encode(map[string]string{"error": runErr.Error()})
+		return
+	}
+	if run != nil && run.Status == RunStatusWaiting {
+		// A delay or an approval suspended the run, it has no result yet
+		w.Header().Set("Content-Type", "application/json")
+		w.WriteHeader(http.StatusAccepted)
+		json.NewEncoder(w).Encode(map[string]string{"run_id": run.ID, "status": run.Status})
+		return
+	}
+
+	var body []byte
+	contentType := "application/json"
+	switch {
+	case resp.Body != "":
+		processed, err := processTemplate(ctx, resp.Placeholders, resp.Body)
+		if err != nil {
+			http.Error(w, "Error building response body", http.StatusInternalServerError)
+			return
+		}
+		body = []byte(processed)
+		if !json.Valid(body) {
+			contentType = "text/plain; charset=utf-8"
+		}
+	case resp.ResultID != "":
+		value := ctx.Get(resp.ResultID)
+		if str, ok := value.(string); ok {
+			body = []byte(str)
+			contentType = "text/plain; charset=utf-8"
+			break
+		}
+		body = toRawJSON(value)
+	default:
+		ctx.mu.RLock()
+		body = toRawJSON(ctx.Results)
+		ctx.mu.RUnlock()
+	}
+
+	w.Header().Set("Content-Type", contentType)
+	for key, value := range resp.Headers {
+		headerValue, err := processTemplate(ctx, resp.Placeholders, value)
+		if err != nil {
+			log.Printf("Error building response header %s: %v", key, err)
+			continue
+		}
+		w.Header().Set(key, headerValue)
+	}
+
+	statusCode := resp.StatusCode
+	if statusCode == 0 {
+		statusCode = http.StatusOK
+	}
+	w.WriteHeader(statusCode)
+	w.Write(body)
+}
@@ -0,0 +1,200 @@
+package models
+
+import (
+	"crypto/hmac"
+	"crypto/sha256"
+	"encoding/base64"
+	"encoding/hex"
+	"fmt"
+	"net/http"
+	"strconv"
+	"strings"
+	"sync"
+	"time"
+)
+
+const (
+	VerificationHMAC   = "hmac"   // HMAC-SHA256 of the body in a configurable header
+	VerificationStripe = "stripe" // Stripe-Signature: t=<timestamp>,v1=<hmac of "t.body">
+	VerificationGitHub = "github" // X-Hub-Signature-256: sha256=<hmac of body>
+)
+
+// defaultSignatureTolerance is how old a signed request may be, and how long
+// signatures are remembered to reject replays
+const defaultSignatureTolerance = 5 * time.Minute
+
+// WebhookVerification rejects webhook requests that are not signed with the
+// shared secret before any action runs
+type WebhookVerification struct {
+	Preset          string `json:"preset,omitempty"`           // "hmac" (default), "stripe" or "github"
+	Secret          string `json:"secret"`                     // signing key, usually a secret placeholder such as "{{STRIPE_WEBHOOK_SECRET}}"
+	Header          string `json:"header,omitempty"`           // signature header for "hmac", defaults to "X-Signature"
+	Encoding        string `json:"encoding,omitempty"`         // "hex" (default) or "base64", for "hmac"
+	TimestampHeader string `json:"timestamp_header,omitempty"` // for "hmac": when set, "<timestamp>.<body>" is signed
+	Tolerance       string `json:"tolerance,omitempty"`        // maximum age of signed timestamps, defaults to 5m
+
+	seenMutex sync.Mutex
+	seen      map[string]time.Time // recently accepted signatures
+}
+
+// verify checks the signature of the request and rejects replays. The
+// signature is remembered until release is called, which the handler does
+// when it turns the request down itself so that the sender can retry it.
+func (v *WebhookVerification) verify(r *http.Request, body []byte) (release func(), err error) {
+	secret, err := processTemplate(&ActionChainContext{}, nil, v.Secret)
+	if err != nil || secret == "" {
+		return nil, fmt.Errorf("webhook secret is not configured")
+	}
+	tolerance, err := parseTimeout(v.Tolerance)
+	if err != nil || tolerance <= 0 {
+		tolerance = defaultSignatureTolerance
+	}
+
+	// Replays are detected on the verified MAC, hex encoded, rather than the
+	// header text, so that the same signature cannot be resent in another case
+	var signature string
+	switch v.Preset {
+	case "", VerificationHMAC:
+		signature, err = v.verifyHMAC(r, body, secret, tolerance)
+	case VerificationStripe:
+		signature, err = verifyStripe(r, body, secret, tolerance)
+	case VerificationGitHub:
+		signature, err = verifyGitHub(r, body, secret)
+	default:
+		err = fmt.Errorf("unknown verification preset: %s", v.Preset)
+	}
+	if err != nil {
+		return nil, err
+	}
+	if err := v.checkReplay(signature, tolerance); err != nil {
+		return nil, err
+	}
+	return func() { v.forget(signature) }, nil
+}
+
+func (v *WebhookVerification) verifyHMAC(r *http.Request, body []byte, secret string, tolerance time.Duration) (string, error) {
+	header := v.Header
+	if header == "" {
+		header = "X-Signature"
+	}
+	signature := strings.TrimPrefix(r.Header.Get(header), "sha256=")
+	if signature == "" {
+		return "", fmt.Errorf("missing %s header", header)
+	}
+
+	signed := body
+	if v.TimestampHeader != "" {
+		timestamp := r.Header.Get(v.TimestampHeader)
+		if err := checkTimestamp(timestamp, tolerance); err != nil {
+			return "", err
+		}
+		signed = append([]byte(timestamp+"."), body...)
+	}
+
+	expected := computeHMAC(secret, signed)
+	var decoded []byte
+	var err error
+	if v.Encoding == "base64" {
+		decoded, err = base64.StdEncoding.DecodeString(signature)
+	} else {
+		decoded, err = hex.DecodeString(signature)
+	}
+	if err != nil || !hmac.Equal(decoded, expected) {
+		return "", fmt.Errorf("invalid signature")
+	}
+	return hex.EncodeToString(decoded), nil
+}
+
+func verifyStripe(r *http.Request, body []byte, secret string, tolerance time.Duration) (string, error) {
+	header := r.Header.Get("Stripe-Signature")
+	if header == "" {
+		return "", fmt.Errorf("missing Stripe-Signature header")
+	}
+
+	var timestamp string
+	var signatures []string
+	for _, part := range strings.Split(header, ",") {
+		key, value, _ := strings.Cut(strings.TrimSpace(part), "=")
+		switch key {
+		case "t":
+			timestamp = value
+		case "v1":
+			signatures = append(signatures, value)
+		}
+	}
+	if err := checkTimestamp(timestamp, tolerance); err != nil {
+		return "", err
+	}
+
+	expected := computeHMAC(secret, append([]byte(timestamp+"."), body...))
+	for _, signature := range signatures {
+		decoded, err := hex.DecodeString(signature)
+		if err == nil && hmac.Equal(decoded, expected) {
+			return hex.EncodeToString(decoded), nil
+		}
+	}
+	return "", fmt.Errorf("invalid signature")
+}
+
+func verifyGitHub(r *http.Request, body []byte, secret string) (string, error) {
+	header := r.Header.Get("X-Hub-Signature-256")
+	signature, ok := strings.CutPrefix(header, "sha256=")
+	if !ok {
+		return "", fmt.Errorf("missing X-Hub-Signature-256 header")
+	}
+	decoded, err := hex.DecodeString(signature)
+	if err != nil || !hmac.Equal(decoded, computeHMAC(secret, body)) {
+		return "", fmt.Errorf("invalid signature")
+	}
+	return hex.EncodeToString(decoded), nil
+}
+
+// checkTimestamp rejects signed timestamps (unix seconds) outside the tolerance
+func checkTimestamp(timestamp string, tolerance time.Duration) error {
+	if timestamp == "" {
+		return fmt.Errorf("missing signature timestamp")
+	}
+	seconds, err := strconv.ParseInt(timestamp, 10, 64)
+	if err != nil {
+		return fmt.Errorf("invalid signature timestamp %q", timestamp)
+	}
+	age := time.Since(time.Unix(seconds, 0))
+	if age > tolerance || age < -tolerance {
+		return fmt.Errorf("signature timestamp outside of the %s tolerance", tolerance)
+	}
+	return nil
+}
+
+// checkReplay rejects a signature already accepted within the tolerance window
+func (v *WebhookVerification) checkReplay(signature string, tolerance time.Duration) error {
+	v.seenMutex.Lock()
+	defer v.seenMutex.Unlock()
+
+	now := time.Now()
+	if v.seen == nil {
+		v.seen = make(map[string]time.Time)
+	}
+	for s, seenAt := range v.seen {
+		if now.Sub(seenAt) > tolerance {
+			delete(v.seen, s)
+		}
+	}
+	if _, ok := v.seen[signature]; ok {
+		return fmt.Errorf("replayed request")
+	}
+	v.seen[signature] = now
+	return nil
+}
+
+// forget drops a signature remembered by checkReplay
+func (v *WebhookVerification) forget(signature string) {
+	v.seenMutex.Lock()
+	defer v.seenMutex.Unlock()
+	delete(v.seen, signature)
+}
+
+func computeHMAC(secret string, data []byte) []byte {
+	mac := hmac.New(sha256.New, []byte(secret))
+	mac.Write(data)
+	return mac.Sum(nil)
+}
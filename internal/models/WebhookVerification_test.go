@@ -0,0 +1,243 @@
+package models
+
+import (
+	"encoding/base64"
+	"encoding/hex"
+	"net/http"
+	"net/http/httptest"
+	"strconv"
+	"strings"
+	"testing"
+	"time"
+)
+
+const testSecret = "whsec_test"
+
+func signHex(secret, data string) string {
+	return hex.EncodeToString(computeHMAC(secret, []byte(data)))
+}
+
+func TestWebhookVerification(t *testing.T) {
+	body := `{"event":"paid"}`
+	now := strconv.FormatInt(time.Now().Unix(), 10)
+	stale := strconv.FormatInt(time.Now().Add(-time.Hour).Unix(), 10)
+
+	tests := []struct {
+		name         string
+		verification *WebhookVerification
+		earlier      map[string]string // headers of a request accepted before
+		headers      map[string]string
+		wantErr      string
+	}{
+		{
+			name:         "hmac hex",
+			verification: &WebhookVerification{Secret: testSecret},
+			headers:      map[string]string{"X-Signature": signHex(testSecret, body)},
+		},
+		{
+			name:         "hmac with sha256 prefix",
+			verification: &WebhookVerification{Secret: testSecret},
+			headers:      map[string]string{"X-Signature": "sha256=" + signHex(testSecret, body)},
+		},
+		{
+			name:         "hmac base64 in a custom header",
+			verification: &WebhookVerification{Preset: VerificationHMAC, Secret: testSecret, Header: "X-Webhook-Signature", Encoding: "base64"},
+			headers: map[string]string{
+				"X-Webhook-Signature": base64.StdEncoding.EncodeToString(computeHMAC(testSecret, []byte(body))),
+			},
+		},
+		{
+			name:         "hmac with timestamp",
+			verification: &WebhookVerification{Secret: testSecret, TimestampHeader: "X-Timestamp"},
+			headers:      map[string]string{"X-Timestamp": now, "X-Signature": signHex(testSecret, now+"."+body)},
+		},
+		{
+			name:         "hmac with stale timestamp",
+			verification: &WebhookVerification{Secret: testSecret, TimestampHeader: "X-Timestamp"},
+			headers:      map[string]string{"X-Timestamp": stale, "X-Signature": signHex(testSecret, stale+"."+body)},
+			wantErr:      "tolerance",
+		},
+		{
+			name:         "hmac with custom tolerance",
+			verification: &WebhookVerification{Secret: testSecret, TimestampHeader: "X-Timestamp", Tolerance: "2h"},
+			headers:      map[string]string{"X-Timestamp": stale, "X-Signature": signHex(testSecret, stale+"."+body)},
+		},
+		{
+			name:         "hmac with missing timestamp",
+			verification: &WebhookVerification{Secret: testSecret, TimestampHeader: "X-Timestamp"},
+			headers:      map[string]string{"X-Signature": signHex(testSecret, body)},
+			wantErr:      "missing signature timestamp",
+		},
+		{
+			name:         "hmac with wrong secret",
+			verification: &WebhookVerification{Secret: testSecret},
+			headers:      map[string]string{"X-Signature": signHex("other", body)},
+			wantErr:      "invalid signature",
+		},
+		{
+			name:         "hmac with malformed signature",
+			verification: &WebhookVerification{Secret: testSecret},
+			headers:      map[string]string{"X-Signature": "not-hex"},
+			wantErr:      "invalid signature",
+		},
+		{
+			name:         "hmac with missing header",
+			verification: &WebhookVerification{Secret: testSecret},
+			wantErr:      "missing X-Signature header",
+		},
+		{
+			name:         "stripe",
+			verification: &WebhookVerification{Preset: VerificationStripe, Secret: testSecret},
+			headers:      map[string]string{"Stripe-Signature": "t=" + now + ",v1=" + signHex(testSecret, now+"."+body)},
+		},
+		{
+			name:         "stripe with several signatures",
+			verification: &WebhookVerification{Preset: VerificationStripe, Secret: testSecret},
+			headers: map[string]string{
+				"Stripe-Signature": "t=" + now + ", v1=" + signHex("old", now+"."+body) + ", v1=" + signHex(testSecret, now+"."+body),
+			},
+		},
+		{
+			name:         "stripe with stale timestamp",
+			verification: &WebhookVerification{Preset: VerificationStripe, Secret: testSecret},
+			headers:      map[string]string{"Stripe-Signature": "t=" + stale + ",v1=" + signHex(testSecret, stale+"."+body)},
+			wantErr:      "tolerance",
+		},
+		{
+			name:         "stripe with wrong secret",
+			verification: &WebhookVerification{Preset: VerificationStripe, Secret: testSecret},
+			headers:      map[string]string{"Stripe-Signature": "t=" + now + ",v1=" + signHex("other", now+"."+body)},
+			wantErr:      "invalid signature",
+		},
+		{
+			name:         "stripe with missing header",
+			verification: &WebhookVerification{Preset: VerificationStripe, Secret: testSecret},
+			wantErr:      "missing Stripe-Signature header",
+		},
+		{
+			name:         "github",
+			verification: &WebhookVerification{Preset: VerificationGitHub, Secret: testSecret},
+			headers:      map[string]string{"X-Hub-Signature-256": "sha256=" + signHex(testSecret, body)},
+		},
+		{
+			name:         "github without prefix",
+			verification: &WebhookVerification{Preset: VerificationGitHub, Secret: testSecret},
+			headers:      map[string]string{"X-Hub-Signature-256": signHex(testSecret, body)},
+			wantErr:      "missing X-Hub-Signature-256 header",
+		},
+		{
+			name:         "github with wrong secret",
+			verification: &WebhookVerification{Preset: VerificationGitHub, Secret: testSecret},
+			headers:      map[string]string{"X-Hub-Signature-256": "sha256=" + signHex("other", body)},
+			wantErr:      "invalid signature",
+		},
+		{
+			name:         "hmac replay",
+			verification: &WebhookVerification{Secret: testSecret},
+			earlier:      map[string]string{"X-Signature": signHex(testSecret, body)},
+			headers:      map[string]string{"X-Signature": signHex(testSecret, body)},
+			wantErr:      "replayed request",
+		},
+		{
+			name:         "hmac replay in upper case",
+			verification: &WebhookVerification{Secret: testSecret},
+			earlier:      map[string]string{"X-Signature": signHex(testSecret, body)},
+			headers:      map[string]string{"X-Signature": strings.ToUpper(signHex(testSecret, body))},
+			wantErr:      "replayed request",
+		},
+		{
+			name:         "stripe replay in upper case",
+			verification: &WebhookVerification{Preset: VerificationStripe, Secret: testSecret},
+			earlier:      map[string]string{"Stripe-Signature": "t=" + now + ",v1=" + signHex(testSecret, now+"."+body)},
+			headers:      map[string]string{"Stripe-Signature": "t=" + now + ",v1=" + strings.ToUpper(signHex(testSecret, now+"."+body))},
+			wantErr:      "replayed request",
+		},
+		{
+			name:         "github replay in upper case",
+			verification: &WebhookVerification{Preset: VerificationGitHub, Secret: testSecret},
+			earlier:      map[string]string{"X-Hub-Signature-256": "sha256=" + signHex(testSecret, body)},
+			headers:      map[string]string{"X-Hub-Signature-256": "sha256=" + strings.ToUpper(signHex(testSecret, body))},
+			wantErr:      "replayed request",
+		},
+		{
+			name:         "unknown preset",
+			verification: &WebhookVerification{Preset: "slack", Secret: testSecret},
+			wantErr:      "unknown verification preset",
+		},
+		{
+			name:         "missing secret",
+			verification: &WebhookVerification{},
+			headers:      map[string]string{"X-Signature": signHex("", body)},
+			wantErr:      "secret is not configured",
+		},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			newRequest := func(headers map[string]string) *http.Request {
+				r := httptest.NewRequest(http.MethodPost, "/hooks/chain/path", strings.NewReader(body))
+				for key, value := range headers {
+					r.Header.Set(key, value)
+				}
+				return r
+			}
+			if tt.earlier != nil {
+				if _, err := tt.verification.verify(newRequest(tt.earlier), []byte(body)); err != nil {
+					t.Fatalf("verify earlier request: %v", err)
+				}
+			}
+			release, err := tt.verification.verify(newRequest(tt.headers), []byte(body))
+			if tt.wantErr == "" {
+				if err != nil {
+					t.Fatalf("verify: %v", err)
+				}
+				if release == nil {
+					t.Errorf("verify returned no release function")
+				}
+				return
+			}
+			if err == nil {
+				t.Fatalf("verify succeeded, want an error containing %q", tt.wantErr)
+			}
+			if !strings.Contains(err.Error(), tt.wantErr) {
+				t.Errorf("verify: %v, want an error containing %q", err, tt.wantErr)
+			}
+		})
+	}
+}
+
+func TestWebhookVerificationReplay(t *testing.T) {
+	body := `{"event":"paid"}`
+	v := &WebhookVerification{Secret: testSecret}
+	signature := signHex(testSecret, body)
+	verify := func() (func(), error) {
+		r := httptest.NewRequest(http.MethodPost, "/hooks/chain/path", strings.NewReader(body))
+		r.Header.Set("X-Signature", signature)
+		return v.verify(r, []byte(body))
+	}
+
+	steps := []struct {
+		name    string
+		release bool // release the signature after this step
+		wantErr bool
+	}{
+		{name: "first delivery", release: true},
+		{name: "retry after release"},
+		{name: "replay", wantErr: true},
+		{name: "replay again", wantErr: true},
+	}
+	for _, step := range steps {
+		release, err := verify()
+		if step.wantErr {
+			if err == nil || !strings.Contains(err.Error(), "replayed request") {
+				t.Fatalf("%s: verify: %v, want a replayed request error", step.name, err)
+			}
+			continue
+		}
+		if err != nil {
+			t.Fatalf("%s: verify: %v", step.name, err)
+		}
+		if step.release {
+			release()
+		}
+	}
+}
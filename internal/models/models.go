@@ -7,20 +7,83 @@ import (
 	"io"
 	"log"
 	"longboy/internal/config"
+	"net"
 	"net/http"
 	"net/url"
 	"reflect"
 	"regexp"
 	"strconv"
 	"strings"
+	"sync"
+	"time"
 
 	"gorm.io/gorm"
 )
 
-var ActivationContext context.Context
-
 type ActionChainContext struct {
 	Results map[string]interface{} `json:"results" gorm:"serializer:json"`
+	mu      sync.RWMutex
+	db      *gorm.DB            // set for the duration of a run, for actions using the database
+	parent  *ActionChainContext // enclosing scope of a child context
+	depth   int                 // nesting of call_chain actions that led to this run
+	hops    int                 // runs in a row started by chain triggers that led to this run
+}
+
+// NewRunContext returns a fresh context for a single run, seeded with a deep
+// copy of the chain's stored context so runs never share state
+func NewRunContext(seed *ActionChainContext) *ActionChainContext {
+	ctx := &ActionChainContext{Results: make(map[string]interface{})}
+	if seed == nil {
+		return ctx
+	}
+	seed.mu.RLock()
+	defer seed.mu.RUnlock()
+	if len(seed.Results) == 0 {
+		return ctx
+	}
+	data, err := json.Marshal(seed.Results)
+	if err != nil {
+		log.Printf("Error copying chain context: %v", err)
+		return ctx
+	}
+	if err := json.Unmarshal(data, &ctx.Results); err != nil {
+		log.Printf("Error copying chain context: %v", err)
+	}
+	return ctx
+}
+
+// Get returns the result stored under key, looking it up in the enclosing
+// scopes of a child context
+func (c *ActionChainContext) Get(key string) interface{} {
+	c.mu.RLock()
+	value, ok := c.Results[key]
+	c.mu.RUnlock()
+	if !ok && c.parent != nil {
+		return c.parent.Get(key)
+	}
+	return value
+}
+
+// child returns a scope for values such as loop variables: it reads through
+// to c, and what is set on it stays out of c
+func (c *ActionChainContext) child() *ActionChainContext {
+	return &ActionChainContext{
+		Results: make(map[string]interface{}),
+		db:      c.db,
+		parent:  c,
+		depth:   c.depth,
+		hops:    c.hops,
+	}
+}
+
+// Set stores a result under key
+func (c *ActionChainContext) Set(key string, value interface{}) {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	if c.Results == nil {
+		c.Results = make(map[string]interface{})
+	}
+	c.Results[key] = value
 }
 
 type Description struct {
@@ -57,24 +120,36 @@ func (d Description) Print() {
 }
 
 type ActionChain struct {
-	ID          string              `json:"id" gorm:"primaryKey"`
-	Trigger     *Trigger            `json:"trigger" gorm:"embedded"`
-	Context     *ActionChainContext `json:"context" gorm:"serializer:json"`
-	Description *Description        `json:"description" gorm:"serializer:json"`
-	Active      bool                `json:"active" gorm:"default:false"`
+	ID                string              `json:"id" gorm:"primaryKey"`
+	Trigger           *Trigger            `json:"trigger" gorm:"embedded"`
+	Context           *ActionChainContext `json:"context" gorm:"serializer:json"`
+	Description       *Description        `json:"description" gorm:"serializer:json"`
+	Active            bool                `json:"active" gorm:"default:false"`
+	MaxConcurrentRuns int                 `json:"max_concurrent_runs,omitempty" gorm:"default:0"`     // 0 means unlimited
+	Timeout           string              `json:"timeout,omitempty" gorm:"type:varchar(20)"`          // deadline of a whole run, e.g. "5m"
+	ErrorActionID     string              `json:"error_action_id,omitempty" gorm:"type:varchar(100)"` // handles failures of actions without their own handler
 }
 
 type Trigger struct {
-	ID                string            `json:"id" gorm:"type:varchar(100)"`
-	Type              string            `json:"type" gorm:"type:varchar(50)"`
-	URL               string            `json:"url" gorm:"type:text"`
-	Method            string            `json:"method" gorm:"type:varchar(10)"`
-	Headers           map[string]string `json:"headers" gorm:"serializer:json"`
-	Body              string            `json:"body" gorm:"type:text"`
-	ResultID          string            `json:"result_id,omitempty" gorm:"type:varchar(100)"`
-	FollowingActionID string            `json:"following_action_id,omitempty" gorm:"type:varchar(100)"`
-	Description       *Description      `json:"description" gorm:"serializer:json"`
-	StopChan          chan struct{}     `json:"-" gorm:"-"`
+	ID                string                 `json:"id" gorm:"type:varchar(100)"`
+	Type              string                 `json:"type" gorm:"type:varchar(50)"`
+	URL               string                 `json:"url" gorm:"type:text"`
+	Method            string                 `json:"method" gorm:"type:varchar(10)"`
+	Headers           map[string]string      `json:"headers" gorm:"serializer:json"`
+	Body              string                 `json:"body" gorm:"type:text"`
+	ResultID          string                 `json:"result_id,omitempty" gorm:"type:varchar(100)"`
+	FollowingActionID string                 `json:"following_action_id,omitempty" gorm:"type:varchar(100)"`
+	Response          *WebhookResponse       `json:"response,omitempty" gorm:"serializer:json"`     // respond with the chain's result instead of acknowledging
+	Dedicated         bool                   `json:"dedicated,omitempty"`                           // listen on the host and port of URL instead of the main server
+	Verification      *WebhookVerification   `json:"verification,omitempty" gorm:"serializer:json"` // reject requests without a valid signature
+	Filter            *TriggerFilter         `json:"filter,omitempty" gorm:"serializer:json"`       // only start runs for matching payloads
+	Schedule          *ScheduleConfig        `json:"schedule,omitempty" gorm:"serializer:json"`     // for "schedule" triggers
+	Poll              *PollConfig            `json:"poll,omitempty" gorm:"serializer:json"`         // for "poll" triggers
+	File              *FileWatchConfig       `json:"file,omitempty" gorm:"serializer:json"`         // for "file" triggers
+	Email             *EmailConfig           `json:"email,omitempty" gorm:"serializer:json"`        // for "email" triggers
+	Chain             *ChainCompletionConfig `json:"chain,omitempty" gorm:"serializer:json"`        // for "chain" triggers
+	Queue             *QueueConfig           `json:"queue,omitempty" gorm:"serializer:json"`        // for "queue" triggers
+	Description       *Description           `json:"description" gorm:"serializer:json"`
 }
 
 func getActionByID(db *gorm.DB, id string) (Action, error) {
@@ -83,12 +158,24 @@ func getActionByID(db *gorm.DB, id string) (Action, error) {
 	return action, err
 }
 
-func (t *Trigger) Exec(ctx *ActionChainContext, db *gorm.DB) error {
-	t.StopChan = make(chan struct{})
-	mux := http.NewServeMux()
-	mux.HandleFunc("/", func(w http.ResponseWriter, r *http.Request) {
-		parsedURL, _ := url.Parse(t.URL)
-		if r.URL.Path != parsedURL.Path {
+// execWebhook handles HTTP requests, mounted on the main server or on a
+// dedicated listener
+func (t *Trigger) execWebhook(rt *ChainRuntime, db *gorm.DB) (stop func(), err error) {
+	chain := rt.chain
+
+	parsedURL, err := url.Parse(t.URL)
+	if err != nil {
+		return nil, fmt.Errorf("failed to parse URL: %v", err)
+	}
+	hookPath := parsedURL.Path
+	if hookPath == "" {
+		hookPath = "/"
+	}
+
+	// path is the request path relative to where the webhook is mounted
+	handle := func(w http.ResponseWriter, r *http.Request, path string) {
+		pathParams, ok := matchPath(hookPath, path)
+		if !ok {
 			http.NotFound(w, r)
 			return
 		}
@@ -96,10 +183,6 @@ func (t *Trigger) Exec(ctx *ActionChainContext, db *gorm.DB) error {
 			http.Error(w, fmt.Sprintf("Invalid request method, expected %s", t.Method), http.StatusMethodNotAllowed)
 			return
 		}
-		fmt.Println("Webhook received successfully")
-		w.WriteHeader(http.StatusOK)
-		w.Write([]byte("Webhook received successfully"))
-
 		// Read the request body
 		body, err := io.ReadAll(r.Body)
 		if err != nil {
@@ -109,69 +192,128 @@ func (t *Trigger) Exec(ctx *ActionChainContext, db *gorm.DB) error {
 		}
 		defer r.Body.Close()
 
-		// Parse the JSON
-		var jsonData interface{}
-		err = json.Unmarshal(body, &jsonData)
+		// Signatures of requests turned down below are released, the sender
+		// may retry them
+		releaseSignature := func() {}
+		if t.Verification != nil {
+			release, err := t.Verification.verify(r, body)
+			if err != nil {
+				log.Printf("Rejected webhook request for chain %s: %v", chain.ID, err)
+				http.Error(w, "Invalid signature", http.StatusUnauthorized)
+				return
+			}
+			releaseSignature = release
+		}
+
+		request, err := newWebhookRequest(r, path, body, pathParams)
 		if err != nil {
-			log.Printf("Error parsing JSON: %v", err)
-			http.Error(w, "Error parsing JSON", http.StatusBadRequest)
+			releaseSignature()
+			log.Printf("Error parsing request body: %v", err)
+			http.Error(w, err.Error(), http.StatusBadRequest)
 			return
 		}
 
-		// Store the parsed JSON in ctx.Results
-		ctx.Results[t.ResultID] = jsonData
+		// Every invocation gets its own context, seeded from the chain's one
+		ctx := NewRunContext(chain.Context)
 
-		// fmt.Printf("Stored in ctx.Results[%s]: %+v\n", t.ResultID, jsonData)
+		// Store the request in ctx.Results
+		ctx.Set(t.ResultID, request)
 
-		// Trigger the following action
-		if t.FollowingActionID != "" {
-			// Execute following actions
-			nextActionID := t.FollowingActionID
-			for nextActionID != "" {
-				nextAction, err := getActionByID(db, nextActionID)
-				if err != nil {
-					log.Printf("failed to get next action: %v", err)
-					break
-				}
-				err = nextAction.Exec(ctx)
-				if err != nil {
-					log.Printf("failed to execute next action: %v", err)
-					break
-				}
-				nextActionID = nextAction.FollowingActionID
-			}
+		if !rt.accepts(db, ctx, request) {
+			releaseSignature()
+			w.WriteHeader(http.StatusOK)
+			w.Write([]byte("Webhook received, filtered out by the trigger filter"))
+			return
+		}
+
+		release, ok := rt.acquire()
+		if !ok {
+			releaseSignature()
+			http.Error(w, "Too many concurrent runs for this action chain", http.StatusTooManyRequests)
+			return
+		}
+
+		// fmt.Printf("Stored in ctx.Results[%s]: %+v\n", t.ResultID, request)
+
+		if t.Response == nil {
+			fmt.Println("Webhook received successfully")
+			w.WriteHeader(http.StatusOK)
+			w.Write([]byte("Webhook received successfully"))
+
+			go func() {
+				defer release()
+				rt.startRun(db, ctx, t.FollowingActionID, request)
+			}()
+			return
+		}
+
+		// Respond with the result of the chain once it completes
+		type outcome struct {
+			run *Run
+			err error
+		}
+		done := make(chan outcome, 1)
+		go func() {
+			defer release()
+			run, err := rt.startRun(db, ctx, t.FollowingActionID, request)
+			done <- outcome{run, err}
+		}()
+
+		timeout, err := parseTimeout(t.Response.Timeout)
+		if err != nil || timeout <= 0 {
+			timeout = defaultResponseTimeout
+		}
+		timer := time.NewTimer(timeout)
+		defer timer.Stop()
+
+		select {
+		case o := <-done:
+			t.Response.write(w, ctx, o.run, o.err)
+		case <-timer.C:
+			http.Error(w, "Timed out waiting for the action chain to complete", http.StatusGatewayTimeout)
+		case <-r.Context().Done():
+			log.Printf("Client went away before chain %s completed", chain.ID)
 		}
-	})
-	fmt.Printf("Listening for webhooks on %s...\n", t.URL)
-	// Parse the URL to get the host and port
-	parsedURL, err := url.Parse(t.URL)
-	if err != nil {
-		return fmt.Errorf("failed to parse URL: %v", err)
 	}
 
-	// Use the host and port from the parsed URL, or default to ":3000" if not specified
-	addr := parsedURL.Host
+	if !t.Dedicated {
+		// Mount the webhook on the main server under /hooks/{chainID}
+		if err := registerHook(chain.ID, handle); err != nil {
+			return nil, err
+		}
+		rt.SetEndpoint(hookPrefix + chain.ID + hookPath)
+		fmt.Printf("Listening for webhooks on %s%s...\n", hookPrefix+chain.ID, hookPath)
+
+		return func() { unregisterHook(chain.ID) }, nil
+	}
+
+	// Dedicated listener on the host and port of the trigger URL
+	listener, err := net.Listen("tcp", parsedURL.Host)
+	if err != nil {
+		return nil, fmt.Errorf("failed to listen on %s: %v", parsedURL.Host, err)
+	}
+	rt.SetEndpoint(t.URL)
+	fmt.Printf("Listening for webhooks on %s...\n", t.URL)
 
 	server := &http.Server{
-		Addr:    addr,
-		Handler: mux,
+		Handler: http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+			handle(w, r, r.URL.Path)
+		}),
 	}
 
 	go func() {
-		if err := server.ListenAndServe(); err != nil && err != http.ErrServerClosed {
-			log.Printf("HTTP server ListenAndServe: %v", err)
+		if err := server.Serve(listener); err != nil && err != http.ErrServerClosed {
+			log.Printf("HTTP server Serve: %v", err)
+			rt.RecordError(err)
 		}
 	}()
 
-	go func() {
-		<-t.StopChan
+	return func() {
 		log.Println("Shutting down the server...")
 		if err := server.Shutdown(context.Background()); err != nil {
 			log.Printf("HTTP server Shutdown: %v", err)
 		}
-	}()
-
-	return nil
+	}, nil
 }
 
 type Placeholder struct {
@@ -185,6 +327,9 @@ type Action struct {
 	Description       string                  `json:"description" gorm:"type:text"`
 	ResultID          string                  `json:"result_id,omitempty" gorm:"type:varchar(100)"`
 	FollowingActionID string                  `json:"following_action_id,omitempty" gorm:"type:varchar(100)"`
+	OnErrorActionID   string                  `json:"on_error_action_id,omitempty" gorm:"type:varchar(100)"`
+	Timeout           string                  `json:"timeout,omitempty" gorm:"type:varchar(20)"` // e.g. "30s", no limit if empty
+	Retry             *RetryPolicy            `json:"retry,omitempty" gorm:"serializer:json"`
 	Placeholders      map[string]*Placeholder `json:"placeholders" gorm:"serializer:json"`
 	Metadata          map[string]interface{}  `json:"metadata" gorm:"serializer:json"`
 }
@@ -311,26 +456,44 @@ func compareValues(left, right interface{}, operator string) (bool, error) {
 	return false, fmt.Errorf("invalid operator for type: %s", operator)
 }
 
-func (a *Action) Exec(ctx *ActionChainContext) error {
+func (a *Action) Exec(runCtx context.Context, ctx *ActionChainContext) error {
 	switch a.Type {
 	case "http":
-		return a.ExecHTTP(ctx)
+		return a.ExecHTTP(runCtx, ctx)
 	case "llm":
-		return a.ExecLLM(ctx)
+		return a.ExecLLM(runCtx, ctx)
 	case "code":
-		return a.ExecCode(ctx)
+		return a.ExecCode(runCtx, ctx)
 	case "if_then":
 		return a.ExecIfThen(ctx)
 	case "loop":
-		return a.ExecLoop(ctx)
+		return a.ExecLoop(runCtx, ctx)
 	case "branch":
 		return a.ExecBranch(ctx)
+	case "enqueue":
+		return a.ExecEnqueue(ctx)
+	case "parallel":
+		return a.ExecParallel(runCtx, ctx)
+	case "foreach":
+		return a.ExecForEach(runCtx, ctx)
+	case "call_chain":
+		return a.ExecCallChain(runCtx, ctx)
+	case "delay":
+		return a.ExecDelay(ctx)
+	case "approval":
+		return a.ExecApproval(ctx)
 	default:
 		return fmt.Errorf("unknown action type: %s", a.Type)
 	}
 }
 
 func (a *Action) ProcessBody(ctx *ActionChainContext, body string) (string, error) {
+	return processTemplate(ctx, a.Placeholders, body)
+}
+
+// processTemplate replaces {{SECRET}} with secret values and [[expr]] with the
+// context values the placeholders point to
+func processTemplate(ctx *ActionChainContext, placeholders map[string]*Placeholder, body string) (string, error) {
 	secretRe := regexp.MustCompile(`{{(.+?)}}`)
 	contextRe := regexp.MustCompile(`\[\[(.+?)\]\]`)
 
@@ -341,11 +504,11 @@ func (a *Action) ProcessBody(ctx *ActionChainContext, body string) (string, erro
 
 	body = contextRe.ReplaceAllStringFunc(body, func(match string) string {
 		expr := strings.Trim(match, "[]")
-		placeholder, ok := a.Placeholders[expr]
+		placeholder, ok := placeholders[expr]
 		if !ok {
 			return match // Return original if not found in placeholders
 		}
-		value := ctx.Results[placeholder.Name]
+		value := ctx.Get(placeholder.Name)
 		current := placeholder.Next
 		for current != nil && current.Name != "" {
 			if mapValue, ok := value.(map[string]interface{}); ok {
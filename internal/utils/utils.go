@@ -1,6 +1,8 @@
 package utils
 
 import (
+	"crypto/rand"
+	"encoding/hex"
 	"sync"
 )
 
@@ -19,3 +21,13 @@ func GetNextActionID() int {
 	actionIDCounter++
 	return actionIDCounter
 }
+
+// NewID returns a random identifier for records created by the server itself
+// (runs, steps, ...), which must stay unique across restarts.
+func NewID() string {
+	b := make([]byte, 16)
+	if _, err := rand.Read(b); err != nil {
+		panic(err)
+	}
+	return hex.EncodeToString(b)
+}
@@ -0,0 +1,89 @@
+// Package server runs Longboy. Programs embedding Longboy call Run from their
+// own main package, after registering their trigger types with the trigger
+// package.
+package server
+
+import (
+	"context"
+	"log"
+	"net/http"
+	"os/signal"
+	"syscall"
+	"time"
+
+	"longboy/api"
+	"longboy/internal/config"
+	"longboy/internal/database"
+	// "longboy/internal/models"
+)
+
+// Run serves the API and the active chains on :8080 until SIGINT or SIGTERM,
+// then shuts down gracefully
+func Run() {
+	cfg := config.GetConfig()
+
+	// apiDirectory := "./openapi/paypal"
+	// templateDirectory := "./templates/paypal"
+	// templates, err := models.LoadAPITemplates(apiDirectory)
+	// if err != nil {
+	// 	log.Fatalf("Failed to load API templates: %v", err)
+	// }
+
+	// err = models.SaveAPITemplates(templates, templateDirectory)
+	// if err != nil {
+	// 	log.Fatalf("Failed to save API templates: %v", err)
+	// }
+
+	// Initialize database
+	db, err := database.InitDB(cfg.GetSecret("DB_PATH"))
+	if err != nil {
+		log.Fatal(err)
+	}
+
+	// Set up API routes
+	api.SetupRoutes(db)
+
+	// Bring back the triggers of chains that were active before the restart
+	if _, err := database.RestoreActiveChains(db); err != nil {
+		log.Printf("Failed to restore active action chains: %v", err)
+	}
+
+	// Resume the runs whose wait ended, including while the server was down
+	database.ResumeWaitingRuns(db)
+
+	// Serve static files from the src directory
+	fs := http.FileServer(http.Dir("./src"))
+	http.Handle("/static/", http.StripPrefix("/static/", fs))
+
+	// Catch-all route for debugging
+	http.HandleFunc("/", func(w http.ResponseWriter, r *http.Request) {
+		log.Printf("Received %s request to %s", r.Method, r.URL.Path)
+		http.Error(w, "Not found...", http.StatusNotFound)
+	})
+
+	server := &http.Server{Addr: ":8080"}
+
+	go func() {
+		log.Println("Server starting on :8080")
+		if err := server.ListenAndServe(); err != nil && err != http.ErrServerClosed {
+			log.Fatal(err)
+		}
+	}()
+
+	// Wait for an interrupt, then stop the triggers, let in-flight runs finish
+	// and drain requests
+	ctx, stop := signal.NotifyContext(context.Background(), syscall.SIGINT, syscall.SIGTERM)
+	defer stop()
+	<-ctx.Done()
+
+	log.Println("Shutting down...")
+	shutdownCtx, cancel := context.WithTimeout(context.Background(), 10*time.Second)
+	defer cancel()
+
+	if err := database.Shutdown(shutdownCtx); err != nil {
+		log.Printf("Runs cancelled at shutdown: %v", err)
+	}
+	if err := server.Shutdown(shutdownCtx); err != nil {
+		log.Printf("HTTP server Shutdown: %v", err)
+	}
+}
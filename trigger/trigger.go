@@ -0,0 +1,55 @@
+// Package trigger lets programs embedding Longboy register their own trigger
+// types next to the built-in ones:
+//
+//	trigger.Register("mqtt", trigger.NewProvider("MQTT messages", startMQTT))
+//	server.Run()
+package trigger
+
+import (
+	"longboy/internal/models"
+)
+
+// Provider starts and stops the triggers of one type, see Register
+type Provider = models.TriggerProvider
+
+// Runtime is the activated chain a provider delivers events to, with Fire.
+// Chain, Context, SetEndpoint and RecordError give the provider the chain,
+// the lifetime of the activation and a way to report its state on
+// /runtime/chains.
+type Runtime = models.ChainRuntime
+
+// Trigger is the trigger configuration of a chain
+type Trigger = models.Trigger
+
+// Chain is an action chain
+type Chain = models.ActionChain
+
+// StartFunc starts a trigger and returns the function that stops it
+type StartFunc = models.TriggerStartFunc
+
+// TypeInfo describes a registered trigger type
+type TypeInfo = models.TriggerTypeInfo
+
+// Errors returned by Runtime.Fire when no run starts
+var (
+	ErrRunFiltered = models.ErrRunFiltered
+	ErrTooManyRuns = models.ErrTooManyRuns
+)
+
+// Register makes a trigger type available to action chains. Registering an
+// existing type, built-in or not, replaces its provider for later
+// activations.
+func Register(triggerType string, provider Provider) {
+	models.RegisterTriggerProvider(triggerType, provider)
+}
+
+// NewProvider returns a provider calling start on each activation of a chain
+// and the returned stop function on its deactivation
+func NewProvider(description string, start StartFunc) Provider {
+	return models.NewTriggerProvider(description, start)
+}
+
+// Types returns the registered trigger types, ordered by type
+func Types() []TypeInfo {
+	return models.ListTriggerTypes()
+}